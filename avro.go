@@ -6,6 +6,7 @@ package avro
 import (
 	"fmt"
 	"io"
+	"strings"
 )
 
 type ValueError struct {
@@ -17,27 +18,67 @@ func (err ValueError) Error() string {
 	return fmt.Sprintf("ValueError. Expect %s, found %v of type %T", err.ExpectedType, err.Value, err.Value)
 }
 
+// PathError reports where in a value an encoding or decoding error occurred.
+// Path elements are record field names, "[i]" for array items and "[key]" for map values.
+type PathError struct {
+	Path []string
+	Err  error
+}
+
+func (err *PathError) Error() string {
+	var path strings.Builder
+	for i, elem := range err.Path {
+		if i > 0 && !strings.HasPrefix(elem, "[") {
+			path.WriteByte('.')
+		}
+		path.WriteString(elem)
+	}
+	return fmt.Sprintf("%s: %v", path.String(), err.Err)
+}
+
+func (err *PathError) Unwrap() error {
+	return err.Err
+}
+
+// WithPath prepends path element elem to err, wrapping err into PathError if needed.
+func WithPath(err error, elem string) error {
+	if err == nil {
+		return nil
+	}
+	if perr, ok := err.(*PathError); ok {
+		// errors may be shared, so build a new one instead of changing perr
+		path := make([]string, 0, len(perr.Path)+1)
+		path = append(append(path, elem), perr.Path...)
+		return &PathError{Path: path, Err: perr.Err}
+	}
+	return &PathError{Path: []string{elem}, Err: err}
+}
+
 type Reader interface {
 	io.Reader
 	io.ByteReader
 }
 
 type Schema interface {
-	Encode(w io.Writer, v interface{})
-	Decode(r Reader) interface{}
+	Encode(w io.Writer, v interface{}) error
+	Decode(r Reader) (interface{}, error)
 	String() string
 	SchemaName() string
 }
 
 type SchemaRepo interface {
-	Append(j string) Schema
-	AppendSchema(name string, schema Schema)
+	Append(j string) (Schema, error)
+	AppendSchema(name string, schema Schema) error
 	Get(name string) Schema
 }
 
 type RecordField struct {
 	Name   string
 	Schema Schema
+	// Default is used in place of the field value missing in data,
+	// only meaningful if HasDefault is set, since nil is a valid default.
+	Default    interface{}
+	HasDefault bool
 }
 
 type Record struct {
@@ -45,22 +86,62 @@ type Record struct {
 	Values []interface{}
 }
 
+// CopyValue returns deep copy of generic value v, so that changing the copy
+// doesn't affect v. Maps, arrays, bytes and records are copied, other values are immutable.
+func CopyValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(t))
+		for key, value := range t {
+			res[key] = CopyValue(value)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(t))
+		for i, item := range t {
+			res[i] = CopyValue(item)
+		}
+		return res
+	case []byte:
+		return append([]byte(nil), t...)
+	case Record:
+		return Record{Schema: t.Schema, Values: CopyValue(t.Values).([]interface{})}
+	}
+	return v
+}
+
+// convert value recovered from panic to error
+func panicError(v interface{}) error {
+	if err, ok := v.(error); ok {
+		return err
+	}
+	return fmt.Errorf("avro: %v", v)
+}
+
+// Encode writes v to w. Unlike calling schema.Encode directly, it never panics,
+// even on schemas which do.
 func Encode(w io.Writer, schema Schema, v interface{}) (err error) {
 	defer func() {
-		err = recover().(error)
+		if r := recover(); r != nil {
+			err = panicError(r)
+		}
 	}()
-	schema.Encode(w, v)
-	return
+	return schema.Encode(w, v)
 }
 
+// Decode reads one value from r. Unlike calling schema.Decode directly, it never panics,
+// even on schemas which do.
 func Decode(r Reader, schema Schema) (v interface{}, err error) {
 	defer func() {
-		err = recover().(error)
+		if r := recover(); r != nil {
+			v, err = nil, panicError(r)
+		}
 	}()
-	v = schema.Decode(r)
-	return
+	return schema.Decode(r)
 }
 
+// SchemaName returns name of the schema v can be encoded with, or empty string if
+// v has no Avro counterpart.
 func SchemaName(v interface{}) string {
 	switch t := v.(type) {
 	case nil:
@@ -75,13 +156,25 @@ func SchemaName(v interface{}) string {
 		return "bytes"
 	case string:
 		return "string"
+	case float32:
+		return "float"
 	case float64:
 		return "double"
 	case Record:
+		if t.Schema == nil {
+			return ""
+		}
 		return t.Schema.SchemaName()
 	case []interface{}:
+		if len(t) == 0 {
+			return "[]"
+		}
 		return "[]" + SchemaName(t[0])
+	case map[string]interface{}:
+		for _, value := range t {
+			return "map[string]" + SchemaName(value)
+		}
+		return "map[string]"
 	}
-	panic("Unserializable!")
 	return ""
 }
@@ -0,0 +1,189 @@
+package binary
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	. "github.com/galtsev/avro"
+	"strconv"
+)
+
+// CanonicalForm returns the Parsing Canonical Form of schema: JSON without docs,
+// aliases, defaults and whitespace, with fullnames and attributes in fixed order.
+// Schemas which read and write data the same way have the same canonical form.
+func CanonicalForm(schema Schema) (string, error) {
+	return canonicalForm(schema, false)
+}
+
+// canonicalForm optionally includes field defaults and block sizes, which matter
+// for encoding records with omitted fields and arrays, but not for the canonical form
+func canonicalForm(schema Schema, encoding bool) (string, error) {
+	var buf bytes.Buffer
+	c := canonicalWriter{buf: &buf, seen: make(map[string]bool), encoding: encoding}
+	if err := c.write(schema); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func writeQuoted(buf *bytes.Buffer, s string) {
+	for i := 0; i < len(s); i++ {
+		// characters json.Marshal escapes
+		if c := s[i]; c < 0x20 || c >= 0x7F || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			data, _ := json.Marshal(s)
+			buf.Write(data)
+			return
+		}
+	}
+	buf.WriteByte('"')
+	buf.WriteString(s)
+	buf.WriteByte('"')
+}
+
+type canonicalWriter struct {
+	buf *bytes.Buffer
+	// named types are written in full at first occurrence, later by fullname
+	seen     map[string]bool
+	encoding bool
+}
+
+func (c canonicalWriter) write(schema Schema) error {
+	buf, seen := c.buf, c.seen
+	switch s := schema.(type) {
+	case NullSchema, BooleanSchema, IntSchema, LongSchema, FloatSchema, DoubleSchema, BytesSchema, StringSchema:
+		writeQuoted(buf, s.SchemaName())
+	case SchemaRef:
+		if !seen[s.Name] {
+			return c.write(*s.Target)
+		}
+		writeQuoted(buf, s.Name)
+	case FixedSchema, EnumSchema, RecordSchema:
+		name := s.SchemaName()
+		if seen[name] {
+			writeQuoted(buf, name)
+			return nil
+		}
+		seen[name] = true
+		buf.WriteString(`{"name":`)
+		writeQuoted(buf, name)
+		switch s := s.(type) {
+		case FixedSchema:
+			buf.WriteString(`,"type":"fixed","size":` + strconv.Itoa(s.Size))
+		case EnumSchema:
+			buf.WriteString(`,"type":"enum","symbols":[`)
+			for i, symbol := range s.Symbols {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				writeQuoted(buf, symbol)
+			}
+			buf.WriteByte(']')
+		case RecordSchema:
+			buf.WriteString(`,"type":"record","fields":[`)
+			for i, f := range s.Fields {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				buf.WriteString(`{"name":`)
+				writeQuoted(buf, f.Name)
+				buf.WriteString(`,"type":`)
+				if err := c.write(f.Schema); err != nil {
+					return err
+				}
+				if c.encoding && f.HasDefault {
+					buf.WriteString(`,"default":`)
+					writeQuoted(buf, fmt.Sprintf("%v", f.Default))
+				}
+				buf.WriteByte('}')
+			}
+			buf.WriteByte(']')
+		}
+		buf.WriteByte('}')
+	case ArraySchema:
+		buf.WriteString(`{"type":"array","items":`)
+		if err := c.write(s.ItemSchema); err != nil {
+			return err
+		}
+		c.writeBlockSize(s.BlockSize)
+		buf.WriteByte('}')
+	case MapSchema:
+		buf.WriteString(`{"type":"map","values":`)
+		if err := c.write(s.ValueSchema); err != nil {
+			return err
+		}
+		c.writeBlockSize(s.BlockSize)
+		buf.WriteByte('}')
+	case UnionSchema:
+		buf.WriteByte('[')
+		for i, option := range s.Options {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := c.write(option); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		return fmt.Errorf("no canonical form for %s", schema)
+	}
+	return nil
+}
+
+func (c canonicalWriter) writeBlockSize(size int) {
+	if c.encoding && size > 0 {
+		c.buf.WriteString(`,"blockSize":` + strconv.Itoa(size))
+	}
+}
+
+// empty fingerprint of CRC-64-AVRO, also its polynomial
+const rabinEmpty uint64 = 0xc15d213aa4d7a795
+
+var rabinTable = func() (table [256]uint64) {
+	for i := range table {
+		fp := uint64(i)
+		for j := 0; j < 8; j++ {
+			fp = (fp >> 1) ^ (rabinEmpty & -(fp & 1))
+		}
+		table[i] = fp
+	}
+	return
+}()
+
+// Rabin returns CRC-64-AVRO fingerprint of data.
+func Rabin(data []byte) uint64 {
+	fp := rabinEmpty
+	for _, b := range data {
+		fp = (fp >> 8) ^ rabinTable[byte(fp)^b]
+	}
+	return fp
+}
+
+// Fingerprint64 returns CRC-64-AVRO fingerprint of the canonical form of schema.
+func Fingerprint64(schema Schema) (uint64, error) {
+	form, err := CanonicalForm(schema)
+	if err != nil {
+		return 0, err
+	}
+	return Rabin([]byte(form)), nil
+}
+
+// FingerprintMD5 returns MD5 fingerprint of the canonical form of schema.
+func FingerprintMD5(schema Schema) ([16]byte, error) {
+	form, err := CanonicalForm(schema)
+	if err != nil {
+		return [16]byte{}, err
+	}
+	return md5.Sum([]byte(form)), nil
+}
+
+// FingerprintSHA256 returns SHA-256 fingerprint of the canonical form of schema.
+func FingerprintSHA256(schema Schema) ([32]byte, error) {
+	form, err := CanonicalForm(schema)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return sha256.Sum256([]byte(form)), nil
+}
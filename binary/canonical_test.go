@@ -0,0 +1,56 @@
+package binary
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCanonicalForm(t *testing.T) {
+	cases := []struct{ schema, canonical string }{
+		{`"int"`, `"int"`},
+		{`{"type": "float"}`, `"float"`},
+		{`{"type": "fixed", "name": "f", "namespace": "ns", "size": 4, "aliases": ["g"]}`,
+			`{"name":"ns.f","type":"fixed","size":4}`},
+		{`{"type": "enum", "name": "e", "symbols": ["A", "B"], "doc": "letters", "default": "A"}`,
+			`{"name":"e","type":"enum","symbols":["A","B"]}`},
+		{`{"type": "array", "items": {"type": "map", "values": "bytes"}}`,
+			`{"type":"array","items":{"type":"map","values":"bytes"}}`},
+		{`{"type": "record", "namespace": "x.y", "name": "foo", "doc": "recursive", "fields": [
+			{"name": "a", "type": "int", "doc": "a field", "default": 1},
+			{"name": "next", "type": ["null", "foo"]}]}`,
+			`{"name":"x.y.foo","type":"record","fields":[{"name":"a","type":"int"},{"name":"next","type":["null","x.y.foo"]}]}`},
+		{`{"type": "record", "name": "pair", "fields": [
+			{"name": "a", "type": {"type": "fixed", "name": "md5", "size": 16}},
+			{"name": "b", "type": "md5"}]}`,
+			`{"name":"pair","type":"record","fields":[{"name":"a","type":{"name":"md5","type":"fixed","size":16}},{"name":"b","type":"md5"}]}`},
+	}
+	for _, c := range cases {
+		form, err := CanonicalForm(parseSchema(t, c.schema))
+		assert.NoError(t, err, c.schema)
+		assert.Equal(t, c.canonical, form)
+	}
+}
+
+func TestFingerprints(t *testing.T) {
+	assert.Equal(t, uint64(7195948357588979594), Rabin([]byte(`"null"`)))
+	assert.Equal(t, uint64(0xc15d213aa4d7a795), Rabin(nil))
+
+	schema := parseSchema(t, `{"type": "record", "namespace": "x.y", "name": "foo", "fields": [
+		{"name": "a", "type": "int"}, {"name": "next", "type": ["null", "foo"]}]}`)
+	fp, err := Fingerprint64(schema)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0xded8d6fae7957e06), fp)
+	md5, err := FingerprintMD5(schema)
+	assert.NoError(t, err)
+	assert.Equal(t, "466e0be48f844e0ac8a6fc90e6d11412", hex.EncodeToString(md5[:]))
+	sha, err := FingerprintSHA256(schema)
+	assert.NoError(t, err)
+	assert.Equal(t, "4e7a6c642f264a9d10449b8dc3153edfa400b784e00153a6401ca275773c207f", hex.EncodeToString(sha[:]))
+
+	// the same type written differently
+	other, err := Fingerprint64(parseSchema(t, `{"name": "x.y.foo", "type": "record", "doc": "x", "fields": [
+		{"type": {"type": "int"}, "name": "a"}, {"name": "next", "type": ["null", "x.y.foo"], "default": null}]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, fp, other)
+}
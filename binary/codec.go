@@ -1,12 +1,10 @@
 package binary
 
 import (
-	"encoding/binary"
-	"errors"
 	"fmt"
 	. "github.com/galtsev/avro"
 	"io"
-	"math"
+	"strconv"
 	"strings"
 )
 
@@ -14,12 +12,12 @@ type NullSchema struct{}
 
 var Null NullSchema
 
-func (NullSchema) Encode(w io.Writer, v interface{}) {
-	return
+func (NullSchema) Encode(w io.Writer, v interface{}) error {
+	return nil
 }
 
-func (NullSchema) Decode(r Reader) interface{} {
-	return nil
+func (NullSchema) Decode(r Reader) (interface{}, error) {
+	return nil, nil
 }
 
 func (NullSchema) String() string {
@@ -34,12 +32,17 @@ type IntSchema struct{}
 
 var Integer IntSchema
 
-func (IntSchema) Encode(w io.Writer, v interface{}) {
-	EncodeVarInt(w, int(v.(int32)))
+func (IntSchema) Encode(w io.Writer, v interface{}) error {
+	i, ok := v.(int32)
+	if !ok {
+		return ValueError{Value: v, ExpectedType: "int"}
+	}
+	return EncodeVarInt(w, int(i))
 }
 
-func (IntSchema) Decode(r Reader) interface{} {
-	return int32(DecodeVarInt(r))
+func (IntSchema) Decode(r Reader) (interface{}, error) {
+	v, err := DecodeVarInt(r)
+	return int32(v), err
 }
 
 func (IntSchema) String() string {
@@ -54,11 +57,15 @@ type LongSchema struct{}
 
 var Long LongSchema
 
-func (LongSchema) Encode(w io.Writer, v interface{}) {
-	EncodeVarInt(w, v.(int))
+func (LongSchema) Encode(w io.Writer, v interface{}) error {
+	i, ok := v.(int)
+	if !ok {
+		return ValueError{Value: v, ExpectedType: "long"}
+	}
+	return EncodeVarInt(w, i)
 }
 
-func (LongSchema) Decode(r Reader) interface{} {
+func (LongSchema) Decode(r Reader) (interface{}, error) {
 	return DecodeVarInt(r)
 }
 
@@ -72,28 +79,18 @@ func (LongSchema) SchemaName() string {
 
 type BytesSchema struct{}
 
-func encodeBytes(w io.Writer, buf []byte) {
-	EncodeVarInt(w, len(buf))
-	_, err := w.Write(buf)
-	check(err)
-}
-
-func decodeBytes(r Reader) []byte {
-	bufLen := DecodeVarInt(r)
-	buf := make([]byte, bufLen, bufLen)
-	_, err := r.Read(buf)
-	check(err)
-	return buf
-}
-
 var Bytes BytesSchema
 
-func (BytesSchema) Encode(w io.Writer, v interface{}) {
-	encodeBytes(w, v.([]byte))
+func (BytesSchema) Encode(w io.Writer, v interface{}) error {
+	buf, ok := v.([]byte)
+	if !ok {
+		return ValueError{Value: v, ExpectedType: "bytes"}
+	}
+	return EncodeBytes(w, buf)
 }
 
-func (BytesSchema) Decode(r Reader) interface{} {
-	return decodeBytes(r)
+func (BytesSchema) Decode(r Reader) (interface{}, error) {
+	return DecodeBytes(r)
 }
 
 func (BytesSchema) String() string {
@@ -108,12 +105,16 @@ type StringSchema struct{}
 
 var String StringSchema
 
-func (StringSchema) Encode(w io.Writer, v interface{}) {
-	encodeBytes(w, []byte(v.(string)))
+func (StringSchema) Encode(w io.Writer, v interface{}) error {
+	s, ok := v.(string)
+	if !ok {
+		return ValueError{Value: v, ExpectedType: "string"}
+	}
+	return EncodeString(w, s)
 }
 
-func (StringSchema) Decode(r Reader) interface{} {
-	return string(decodeBytes(r))
+func (StringSchema) Decode(r Reader) (interface{}, error) {
+	return DecodeString(r)
 }
 
 func (StringSchema) String() string {
@@ -132,27 +133,50 @@ func (BooleanSchema) String() string {
 	return "BooleanCodec"
 }
 
-func (BooleanSchema) Encode(w io.Writer, v interface{}) {
-	b := v.(bool)
-	var buf [1]byte
-	if b {
-		buf[0] = 1
+func (BooleanSchema) Encode(w io.Writer, v interface{}) error {
+	b, ok := v.(bool)
+	if !ok {
+		return ValueError{Value: v, ExpectedType: "boolean"}
 	}
-	_, err := w.Write(buf[:])
-	check(err)
+	return EncodeBoolean(w, b)
 }
 
-func (BooleanSchema) Decode(r Reader) interface{} {
-	var buf [1]byte
-	_, err := r.Read(buf[:])
-	check(err)
-	return buf[0] == 1
+func (BooleanSchema) Decode(r Reader) (interface{}, error) {
+	return DecodeBoolean(r)
 }
 
 func (BooleanSchema) SchemaName() string {
 	return "boolean"
 }
 
+type FloatSchema struct{}
+
+var Float FloatSchema
+
+func (FloatSchema) String() string {
+	return "FloatCodec"
+}
+
+func (FloatSchema) Encode(w io.Writer, v interface{}) error {
+	f, ok := v.(float32)
+	if !ok {
+		return ValueError{Value: v, ExpectedType: "float"}
+	}
+	return EncodeFloat(w, f)
+}
+
+func (FloatSchema) Decode(r Reader) (interface{}, error) {
+	f, err := DecodeFloat(r)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (FloatSchema) SchemaName() string {
+	return "float"
+}
+
 type DoubleSchema struct{}
 
 var Double DoubleSchema
@@ -161,85 +185,184 @@ func (DoubleSchema) String() string {
 	return "DoubleCodec"
 }
 
-func (DoubleSchema) Encode(w io.Writer, v interface{}) {
-	var buf [8]byte
-	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v.(float64)))
-	_, err := w.Write(buf[:])
-	check(err)
+func (DoubleSchema) Encode(w io.Writer, v interface{}) error {
+	f, ok := v.(float64)
+	if !ok {
+		return ValueError{Value: v, ExpectedType: "double"}
+	}
+	return EncodeDouble(w, f)
 }
 
-func (DoubleSchema) Decode(r Reader) interface{} {
-	var buf [8]byte
-	_, err := r.Read(buf[:])
-	check(err)
-	bits := binary.LittleEndian.Uint64(buf[:])
-	return math.Float64frombits(bits)
+func (DoubleSchema) Decode(r Reader) (interface{}, error) {
+	f, err := DecodeDouble(r)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
 func (DoubleSchema) SchemaName() string {
 	return "double"
 }
 
+// fullname of a named type: name qualified with namespace unless it is already dotted
+func fullName(name, namespace string) string {
+	if namespace == "" || strings.Contains(name, ".") {
+		return name
+	}
+	return namespace + "." + name
+}
+
 type FixedSchema struct {
-	Name string
-	Size int
+	Name      string
+	Namespace string
+	Size      int
 }
 
 func (schema FixedSchema) String() string {
-	return fmt.Sprintf("Fixed<%s:%d>", schema.Name, schema.Size)
+	return fmt.Sprintf("Fixed<%s:%d>", schema.SchemaName(), schema.Size)
 }
 
-func (schema FixedSchema) Encode(w io.Writer, v interface{}) {
-	buf := v.([]byte)
-	if len(buf) != schema.Size {
-		panic(ValueError{Value: v, ExpectedType: fmt.Sprintf("[]bytes of length %d", schema.Size)})
+func (schema FixedSchema) Encode(w io.Writer, v interface{}) error {
+	buf, ok := v.([]byte)
+	if !ok || len(buf) != schema.Size {
+		return ValueError{Value: v, ExpectedType: fmt.Sprintf("[]bytes of length %d", schema.Size)}
 	}
 	_, err := w.Write(buf)
-	check(err)
+	return err
 }
 
-func (schema FixedSchema) Decode(r Reader) interface{} {
+func (schema FixedSchema) Decode(r Reader) (interface{}, error) {
 	buf := make([]byte, schema.Size)
-	_, err := r.Read(buf)
-	check(err)
-	return buf
+	_, err := io.ReadFull(r, buf)
+	return buf, err
 }
 
 func (schema FixedSchema) SchemaName() string {
-	return schema.Name
+	return fullName(schema.Name, schema.Namespace)
+}
+
+type EnumSchema struct {
+	Name      string
+	Namespace string
+	Symbols   []string
+	Default   string
+}
+
+func (schema EnumSchema) String() string {
+	return fmt.Sprintf("Enum<%s:%s>", schema.SchemaName(), strings.Join(schema.Symbols, ","))
+}
+
+// SymbolIndex returns index of symbol in Symbols, -1 if not found.
+func (schema EnumSchema) SymbolIndex(symbol string) int {
+	for i, s := range schema.Symbols {
+		if s == symbol {
+			return i
+		}
+	}
+	return -1
+}
+
+func (schema EnumSchema) Encode(w io.Writer, v interface{}) error {
+	symbol, ok := v.(string)
+	index := -1
+	if ok {
+		index = schema.SymbolIndex(symbol)
+	}
+	if index < 0 {
+		return ValueError{Value: v, ExpectedType: schema.String()}
+	}
+	return EncodeVarInt(w, index)
+}
+
+func (schema EnumSchema) Decode(r Reader) (interface{}, error) {
+	index, err := DecodeVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 || index >= len(schema.Symbols) {
+		return nil, ValueError{Value: index, ExpectedType: fmt.Sprintf("%s symbol index", schema.SchemaName())}
+	}
+	return schema.Symbols[index], nil
+}
+
+func (schema EnumSchema) SchemaName() string {
+	return fullName(schema.Name, schema.Namespace)
+}
+
+// block sizes to write n items in blocks of at most blockSize items, all in one block if blockSize <= 0
+func blockSizes(n, blockSize int) []int {
+	if n == 0 {
+		return nil
+	}
+	if blockSize <= 0 || blockSize >= n {
+		return []int{n}
+	}
+	var res []int
+	for ; n > blockSize; n -= blockSize {
+		res = append(res, blockSize)
+	}
+	return append(res, n)
+}
+
+// IndexPath returns element of PathError path for array item i.
+func IndexPath(i int) string {
+	return "[" + strconv.Itoa(i) + "]"
+}
+
+// KeyPath returns element of PathError path for map value of key.
+func KeyPath(key string) string {
+	return "[" + key + "]"
 }
 
 type ArraySchema struct {
 	ItemSchema Schema
+	// max items per block on encoding, unlimited if 0
+	BlockSize int
 }
 
 func (schema ArraySchema) String() string {
 	return fmt.Sprintf("ArrayCodec<%s>", schema.ItemSchema)
 }
 
-func (schema ArraySchema) Encode(w io.Writer, v interface{}) {
-	arr := v.([]interface{})
-	EncodeVarInt(w, len(arr))
-	for _, item := range arr {
-		schema.ItemSchema.Encode(w, item)
+func (schema ArraySchema) Encode(w io.Writer, v interface{}) error {
+	arr, ok := v.([]interface{})
+	if !ok {
+		return ValueError{Value: v, ExpectedType: schema.String()}
 	}
-	_, err := w.Write([]byte{0})
-	check(err)
+	index := 0
+	for _, size := range blockSizes(len(arr), schema.BlockSize) {
+		if err := EncodeVarInt(w, size); err != nil {
+			return err
+		}
+		for _, item := range arr[index : index+size] {
+			if err := schema.ItemSchema.Encode(w, item); err != nil {
+				return WithPath(err, IndexPath(index))
+			}
+			index++
+		}
+	}
+	return EncodeVarInt(w, 0)
 }
 
-func (schema ArraySchema) Decode(r Reader) interface{} {
-	arrLen := DecodeVarInt(r)
-	buf := make([]interface{}, arrLen)
-	for i := range buf {
-		buf[i] = schema.ItemSchema.Decode(r)
-	}
-	//TODO: chanked arrays
-	b, err := r.ReadByte()
-	check(err)
-	if b != byte(0) {
-		panic(ValueError{b, "byte(0)"})
+func (schema ArraySchema) Decode(r Reader) (interface{}, error) {
+	buf := make([]interface{}, 0)
+	for {
+		count, err := DecodeBlockCount(r)
+		if err != nil || count == 0 {
+			return buf, err
+		}
+		if err = checkLength(r, "array", len(buf)+count); err != nil {
+			return nil, err
+		}
+		for i := 0; i < count; i++ {
+			item, err := schema.ItemSchema.Decode(r)
+			if err != nil {
+				return nil, WithPath(err, IndexPath(len(buf)))
+			}
+			buf = append(buf, item)
+		}
 	}
-	return buf
 }
 
 func (schema ArraySchema) SchemaName() string {
@@ -248,60 +371,109 @@ func (schema ArraySchema) SchemaName() string {
 
 type MapSchema struct {
 	ValueSchema Schema
+	// max entries per block on encoding, unlimited if 0
+	BlockSize int
 }
 
-func (schema MapSchema) Encode(w io.Writer, v interface{}) {
-	m := v.(map[string]interface{})
-	EncodeVarInt(w, len(m))
-	for key, val := range m {
-		String.Encode(w, key)
-		schema.ValueSchema.Encode(w, val)
+func (schema MapSchema) Encode(w io.Writer, v interface{}) error {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return ValueError{Value: v, ExpectedType: schema.String()}
+	}
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	for _, size := range blockSizes(len(keys), schema.BlockSize) {
+		if err := EncodeVarInt(w, size); err != nil {
+			return err
+		}
+		for _, key := range keys[:size] {
+			if err := String.Encode(w, key); err != nil {
+				return err
+			}
+			if err := schema.ValueSchema.Encode(w, m[key]); err != nil {
+				return WithPath(err, KeyPath(key))
+			}
+		}
+		keys = keys[size:]
 	}
-	EncodeVarInt(w, 0)
+	return EncodeVarInt(w, 0)
 }
 
-func (schema MapSchema) Decode(r Reader) interface{} {
-	mapLen := DecodeVarInt(r)
+func (schema MapSchema) Decode(r Reader) (interface{}, error) {
 	res := make(map[string]interface{})
-	for i := 0; i < mapLen; i++ {
-		key := String.Decode(r).(string)
-		value := schema.ValueSchema.Decode(r)
-		res[key] = value
+	for {
+		count, err := DecodeBlockCount(r)
+		if err != nil || count == 0 {
+			return res, err
+		}
+		if err = checkLength(r, "map", len(res)+count); err != nil {
+			return nil, err
+		}
+		for i := 0; i < count; i++ {
+			key, err := DecodeBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			value, err := schema.ValueSchema.Decode(r)
+			if err != nil {
+				return nil, WithPath(err, KeyPath(string(key)))
+			}
+			res[string(key)] = value
+		}
 	}
-	_ = DecodeVarInt(r)
-	return res
 }
 
 func (schema MapSchema) String() string {
 	return fmt.Sprintf("MapSchema<%s>", schema.ValueSchema.SchemaName())
 }
 
-// TODO:
 func (schema MapSchema) SchemaName() string {
-	return "map"
+	return "map[string]" + schema.ValueSchema.SchemaName()
 }
 
 type RecordSchema struct {
-	Name   string
-	Fields []RecordField
+	Name      string
+	Namespace string
+	Fields    []RecordField
 }
 
-func (schema RecordSchema) Encode(w io.Writer, v interface{}) {
-	rec := v.(Record)
-	if len(rec.Values) != len(schema.Fields) {
-		panic(errors.New(fmt.Sprintf("Record length mismatch. Provided: %d, expected: %d", len(rec.Values), len(schema.Fields))))
+func (schema RecordSchema) Encode(w io.Writer, v interface{}) error {
+	rec, ok := v.(Record)
+	if !ok {
+		return ValueError{Value: v, ExpectedType: schema.SchemaName()}
 	}
-	for i, item := range rec.Values {
-		schema.Fields[i].Schema.Encode(w, item)
+	// trailing fields with defaults may be omitted
+	if len(rec.Values) > len(schema.Fields) ||
+		len(rec.Values) < len(schema.Fields) && !schema.Fields[len(rec.Values)].HasDefault {
+		return fmt.Errorf("Record length mismatch. Provided: %d, expected: %d", len(rec.Values), len(schema.Fields))
 	}
+	for i, field := range schema.Fields {
+		var item interface{}
+		if i < len(rec.Values) {
+			item = rec.Values[i]
+		} else if field.HasDefault {
+			item = field.Default
+		} else {
+			return WithPath(fmt.Errorf("missing value without default"), field.Name)
+		}
+		if err := field.Schema.Encode(w, item); err != nil {
+			return WithPath(err, field.Name)
+		}
+	}
+	return nil
 }
 
-func (schema RecordSchema) Decode(r Reader) interface{} {
+func (schema RecordSchema) Decode(r Reader) (interface{}, error) {
 	rec := Record{Schema: schema, Values: make([]interface{}, len(schema.Fields))}
 	for i, c := range schema.Fields {
-		rec.Values[i] = c.Schema.Decode(r)
+		var err error
+		if rec.Values[i], err = c.Schema.Decode(r); err != nil {
+			return nil, WithPath(err, c.Name)
+		}
 	}
-	return rec
+	return rec, nil
 }
 
 func (schema RecordSchema) String() string {
@@ -309,39 +481,92 @@ func (schema RecordSchema) String() string {
 	for _, f := range schema.Fields {
 		fields = append(fields, fmt.Sprintf("%s: %s", f.Name, f.Schema.String()))
 	}
-	return fmt.Sprintf("%s<%s>", schema.Name, strings.Join(fields, ","))
+	return fmt.Sprintf("%s<%s>", schema.SchemaName(), strings.Join(fields, ","))
 }
 
 func (schema RecordSchema) SchemaName() string {
-	return schema.Name
+	return fullName(schema.Name, schema.Namespace)
 }
 
 type UnionSchema struct {
 	Options []Schema
 }
 
-func (UnionSchema) String() string {
-	return "UnionCodec"
-}
-
-func (schema UnionSchema) getOptionForValue(v interface{}) (index int, option Schema) {
+func (schema UnionSchema) String() string {
+	var options []string
+	for _, option := range schema.Options {
+		options = append(options, option.String())
+	}
+	return fmt.Sprintf("UnionCodec<%s>", strings.Join(options, ","))
+}
+
+// Enum values are plain strings, so a string matching a symbol of an enum branch
+// is written to that branch, even if the union has a string branch too.
+// This keeps decoded enum values on their branch when they are encoded again.
+func (schema UnionSchema) getOptionForValue(v interface{}) (index int, option Schema, err error) {
+	if symbol, ok := v.(string); ok {
+		for index, option = range schema.Options {
+			if enum, ok := deref(option).(EnumSchema); ok && enum.SymbolIndex(symbol) >= 0 {
+				return
+			}
+		}
+	}
 	valueSchema := SchemaName(v)
 	for index, option = range schema.Options {
 		if option.SchemaName() == valueSchema {
 			return
 		}
 	}
-	panic(ValueError{Value: v, ExpectedType: schema.String()})
+	// a union may hold only one array and one map, so any array or map value belongs to it
+	switch v.(type) {
+	case []interface{}:
+		for index, option = range schema.Options {
+			if _, ok := option.(ArraySchema); ok {
+				return
+			}
+		}
+	case map[string]interface{}:
+		for index, option = range schema.Options {
+			if _, ok := option.(MapSchema); ok {
+				return
+			}
+		}
+	case []byte:
+		// without bytes branch, bytes are written to fixed branch of their size
+		for index, option = range schema.Options {
+			if fixed, ok := deref(option).(FixedSchema); ok && fixed.Size == len(v.([]byte)) {
+				return
+			}
+		}
+	}
+	return 0, nil, ValueError{Value: v, ExpectedType: schema.String()}
 }
 
-func (schema UnionSchema) Encode(w io.Writer, v interface{}) {
-	index, option := schema.getOptionForValue(v)
-	EncodeVarInt(w, index)
-	option.Encode(w, v)
+// OptionIndex returns index of the union branch value v belongs to.
+func (schema UnionSchema) OptionIndex(v interface{}) (int, error) {
+	index, _, err := schema.getOptionForValue(v)
+	return index, err
 }
 
-func (schema UnionSchema) Decode(r Reader) interface{} {
-	ind := DecodeVarInt(r)
+func (schema UnionSchema) Encode(w io.Writer, v interface{}) error {
+	index, option, err := schema.getOptionForValue(v)
+	if err != nil {
+		return err
+	}
+	if err = EncodeVarInt(w, index); err != nil {
+		return err
+	}
+	return option.Encode(w, v)
+}
+
+func (schema UnionSchema) Decode(r Reader) (interface{}, error) {
+	ind, err := DecodeVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if ind < 0 || ind >= len(schema.Options) {
+		return nil, ValueError{Value: ind, ExpectedType: fmt.Sprintf("union index below %d", len(schema.Options))}
+	}
 	return schema.Options[ind].Decode(r)
 }
 
@@ -349,3 +574,27 @@ func (schema UnionSchema) Decode(r Reader) interface{} {
 func (schema UnionSchema) SchemaName() string {
 	return "union"
 }
+
+// SchemaRef refers to a named type by name before its definition is complete,
+// which is what makes recursive schemas possible. Target is filled in by the parser
+// once the definition is parsed.
+type SchemaRef struct {
+	Name   string
+	Target *Schema
+}
+
+func (schema SchemaRef) String() string {
+	return schema.Name
+}
+
+func (schema SchemaRef) Encode(w io.Writer, v interface{}) error {
+	return (*schema.Target).Encode(w, v)
+}
+
+func (schema SchemaRef) Decode(r Reader) (interface{}, error) {
+	return (*schema.Target).Decode(r)
+}
+
+func (schema SchemaRef) SchemaName() string {
+	return schema.Name
+}
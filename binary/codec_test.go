@@ -2,8 +2,10 @@ package binary
 
 import (
 	"bytes"
+	"errors"
 	. "github.com/galtsev/avro"
 	"github.com/stretchr/testify/assert"
+	"io"
 
 	"strconv"
 	"testing"
@@ -35,7 +37,7 @@ var (
 		a []interface{}
 		b []byte
 	}{
-		{[]interface{}{}, []byte{0, 0}},
+		{[]interface{}{}, []byte{0}},
 		{[]interface{}{0}, []byte{2, 0, 0}},
 		{[]interface{}{1, -2}, []byte{4, 2, 3, 0}},
 	}
@@ -52,7 +54,8 @@ func TestLongCodecEncode(t *testing.T) {
 func TestLongCodecDecode(t *testing.T) {
 	for _, data := range longData {
 		buf := bytes.NewBuffer(data.b)
-		v := Long.Decode(buf)
+		v, err := Long.Decode(buf)
+		assert.NoError(t, err)
 		assert.Equal(t, data.i, v.(int))
 	}
 }
@@ -74,7 +77,8 @@ func TestStringCodecDecode(t *testing.T) {
 	for _, s := range stringArgs {
 		encoded := append(zlen(s), []byte(s)...)
 		r := bytes.NewBuffer(encoded)
-		v := String.Decode(r)
+		v, err := String.Decode(r)
+		assert.NoError(t, err)
 		assert.Equal(t, s, v.(string))
 	}
 }
@@ -92,7 +96,8 @@ func TestArrayDecode(t *testing.T) {
 	codec := ArraySchema{ItemSchema: Long}
 	for _, data := range arrayData {
 		r := bytes.NewBuffer(data.b)
-		v := codec.Decode(r)
+		v, err := codec.Decode(r)
+		assert.NoError(t, err)
 		assert.Equal(t, data.a, v)
 	}
 }
@@ -138,7 +143,8 @@ func TestBooleanEncode(t *testing.T) {
 func TestBooleanDecode(t *testing.T) {
 	for _, data := range boolData {
 		r := bytes.NewBuffer(data.b)
-		v := Boolean.Decode(r)
+		v, err := Boolean.Decode(r)
+		assert.NoError(t, err)
 		assert.Equal(t, data.v, v.(bool))
 	}
 }
@@ -158,20 +164,20 @@ var recordData = []struct {
 }{
 	{
 		n: "long,long",
-		c: []RecordField{RecordField{"a", Long}, RecordField{"b", Long}},
+		c: []RecordField{RecordField{Name: "a", Schema: Long}, RecordField{Name: "b", Schema: Long}},
 		v: []interface{}{1, -5},
 		b: []byte{2, 9},
 	},
 	{
 		n: "string,long",
-		c: []RecordField{RecordField{"a", String}, RecordField{"b", Long}},
+		c: []RecordField{RecordField{Name: "a", Schema: String}, RecordField{Name: "b", Schema: Long}},
 		v: []interface{}{"one", 7},
 		b: []byte{6, 'o', 'n', 'e', 14},
 	},
 	// array in record
 	{
 		n: "long,[]bool",
-		c: []RecordField{RecordField{"id", Long}, RecordField{"flags", ArraySchema{Boolean}}},
+		c: []RecordField{RecordField{Name: "id", Schema: Long}, RecordField{Name: "flags", Schema: ArraySchema{ItemSchema: Boolean}}},
 		v: []interface{}{3, []interface{}{true, false, true}},
 		b: []byte{6, 6, 1, 0, 1, 0},
 	},
@@ -179,14 +185,14 @@ var recordData = []struct {
 	{
 		n: "name,rec<bool,long>",
 		c: []RecordField{
-			RecordField{"name", String},
+			RecordField{Name: "name", Schema: String},
 			RecordField{
-				"rec",
-				RecordSchema{
+				Name: "rec",
+				Schema: RecordSchema{
 					Name: "sub",
 					Fields: []RecordField{
-						RecordField{"b", Boolean},
-						RecordField{"l", Long},
+						RecordField{Name: "b", Schema: Boolean},
+						RecordField{Name: "l", Schema: Long},
 					},
 				},
 			},
@@ -210,7 +216,8 @@ func TestRecordDecode(t *testing.T) {
 	for _, data := range recordData {
 		r := bytes.NewBuffer(data.b)
 		codec := RecordSchema{Name: "rec", Fields: data.c}
-		v := codec.Decode(r)
+		v, err := codec.Decode(r)
+		assert.NoError(t, err)
 		expected := Record{Schema: codec, Values: data.v}
 		assert.Equal(t, expected, v.(Record), data.n)
 	}
@@ -221,7 +228,8 @@ func TestDoubleEncodeDecode(t *testing.T) {
 		var w bytes.Buffer
 		Double.Encode(&w, f)
 		r := bytes.NewBuffer(w.Bytes())
-		v := Double.Decode(r)
+		v, err := Double.Decode(r)
+		assert.NoError(t, err)
 		assert.Equal(t, f, v.(float64))
 	}
 }
@@ -248,7 +256,8 @@ var (
 func TestMapDecode(t *testing.T) {
 	for _, data := range mapData {
 		r := bytes.NewBuffer(data.b)
-		v := data.c.Decode(r)
+		v, err := data.c.Decode(r)
+		assert.NoError(t, err)
 		assert.Equal(t, data.v, v, data.n)
 	}
 }
@@ -262,8 +271,9 @@ type testData struct {
 func testSchema(t *testing.T, schema Schema, data []interface{}, msg string) {
 	for _, value := range data {
 		var w bytes.Buffer
-		schema.Encode(&w, value)
-		decoded := schema.Decode(&w)
+		assert.NoError(t, schema.Encode(&w, value), msg)
+		decoded, err := schema.Decode(&w)
+		assert.NoError(t, err)
 		assert.Equal(t, value, decoded, msg)
 	}
 }
@@ -292,3 +302,167 @@ func TestUnion(t *testing.T) {
 	data := []interface{}{"abba", nil, int32(1), int32(3), int32(-11), "hello", "\n", int32(667)}
 	testSchema(t, schema, data, "Union<null,int,string>")
 }
+
+func TestFloatEncodeDecode(t *testing.T) {
+	for _, f := range []float32{0, 1.1, 1.0 / 3.0, -123e4} {
+		var w bytes.Buffer
+		Float.Encode(&w, f)
+		assert.Equal(t, 4, w.Len())
+		r := bytes.NewBuffer(w.Bytes())
+		v, err := Float.Decode(r)
+		assert.NoError(t, err)
+		assert.Equal(t, f, v.(float32))
+	}
+}
+
+func TestUnionFloat(t *testing.T) {
+	schema := UnionSchema{Options: []Schema{Null, Float, Double}}
+	data := []interface{}{float32(1.5), nil, float64(2.5), float32(-0.25)}
+	testSchema(t, schema, data, "Union<null,float,double>")
+}
+
+var suitSchema = EnumSchema{Name: "Suit", Symbols: []string{"SPADES", "HEARTS", "DIAMONDS", "CLUBS"}}
+
+func TestEnum(t *testing.T) {
+	var w bytes.Buffer
+	suitSchema.Encode(&w, "DIAMONDS")
+	assert.Equal(t, []byte{4}, w.Bytes())
+	testSchema(t, suitSchema, []interface{}{"SPADES", "HEARTS", "DIAMONDS", "CLUBS"}, "enum")
+}
+
+func TestEnumUnknownSymbol(t *testing.T) {
+	var w bytes.Buffer
+	assert.IsType(t, ValueError{}, suitSchema.Encode(&w, "JOKER"))
+	_, err := suitSchema.Decode(bytes.NewBuffer([]byte{8}))
+	assert.IsType(t, ValueError{}, err)
+}
+
+func TestUnionEnum(t *testing.T) {
+	schema := UnionSchema{Options: []Schema{Null, suitSchema}}
+	testSchema(t, schema, []interface{}{nil, "CLUBS", "SPADES"}, "Union<null,Suit>")
+}
+
+func TestUnionStringEnum(t *testing.T) {
+	// symbols go to the enum branch, so decoded enum values keep their branch
+	schema := UnionSchema{Options: []Schema{Null, String, suitSchema}}
+	testSchema(t, schema, []interface{}{nil, "CLUBS", "joker"}, "Union<null,string,Suit>")
+	var buf bytes.Buffer
+	assert.NoError(t, schema.Encode(&buf, "CLUBS"))
+	assert.Equal(t, []byte{4, 6}, buf.Bytes())
+	buf.Reset()
+	assert.NoError(t, schema.Encode(&buf, "joker"))
+	assert.Equal(t, byte(2), buf.Bytes()[0])
+}
+
+func TestUnionFixed(t *testing.T) {
+	md5 := FixedSchema{Name: "md5", Size: 2}
+	schema := UnionSchema{Options: []Schema{Null, md5, FixedSchema{Name: "sha", Size: 3}}}
+	testSchema(t, schema, []interface{}{nil, []byte{1, 2}, []byte{1, 2, 3}}, "Union<null,md5,sha>")
+	var buf bytes.Buffer
+	assert.NoError(t, schema.Encode(&buf, []byte{1, 2}))
+	assert.Equal(t, []byte{2, 1, 2}, buf.Bytes())
+	assert.IsType(t, ValueError{}, schema.Encode(&buf, []byte{1}))
+
+	// bytes branch takes precedence
+	buf.Reset()
+	assert.NoError(t, UnionSchema{Options: []Schema{md5, Bytes}}.Encode(&buf, []byte{1, 2}))
+	assert.Equal(t, []byte{2, 4, 1, 2}, buf.Bytes())
+}
+
+func TestMap(t *testing.T) {
+	schema := MapSchema{ValueSchema: ArraySchema{ItemSchema: String}}
+	data := []interface{}{
+		map[string]interface{}{},
+		map[string]interface{}{"a": []interface{}{"x", "y"}, "b": []interface{}{}},
+	}
+	testSchema(t, schema, data, "map[string][]string")
+}
+
+func TestUnionMap(t *testing.T) {
+	schema := UnionSchema{Options: []Schema{Null, MapSchema{ValueSchema: Long}}}
+	data := []interface{}{nil, map[string]interface{}{}, map[string]interface{}{"one": 1, "two": 2}}
+	testSchema(t, schema, data, "Union<null,map[string]long>")
+}
+
+func TestArrayBlocks(t *testing.T) {
+	schema := ArraySchema{ItemSchema: Long, BlockSize: 2}
+	var w bytes.Buffer
+	schema.Encode(&w, []interface{}{1, 2, 3, 4, 5})
+	assert.Equal(t, []byte{4, 2, 4, 4, 6, 8, 2, 10, 0}, w.Bytes())
+	testSchema(t, schema, []interface{}{[]interface{}{}, []interface{}{1, 2, 3, 4, 5}}, "[]long in blocks of 2")
+}
+
+func TestArrayDecodeNegativeBlockCount(t *testing.T) {
+	// block of 2 items with byte size, then a block of 1 item
+	r := bytes.NewBuffer([]byte{3, 4, 2, 4, 2, 6, 0, 0xFF})
+	v, err := ArraySchema{ItemSchema: Long}.Decode(r)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{1, 2, 3}, v)
+	assert.Equal(t, []byte{0xFF}, r.Bytes())
+}
+
+func TestMapBlocks(t *testing.T) {
+	schema := MapSchema{ValueSchema: Long, BlockSize: 1}
+	var w bytes.Buffer
+	schema.Encode(&w, map[string]interface{}{"a": 1, "b": 2})
+	assert.Equal(t, 9, w.Len())
+	testSchema(t, schema, []interface{}{map[string]interface{}{"a": 1, "b": 2, "c": 3}}, "map[string]long in blocks of 1")
+	// block of 1 entry with byte size, then a block of 1 entry
+	r := bytes.NewBuffer([]byte{1, 6, 2, 'a', 2, 2, 2, 'b', 4, 0})
+	v, err := MapSchema{ValueSchema: Long}.Decode(r)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": 1, "b": 2}, v)
+	assert.Equal(t, 0, r.Len())
+}
+
+func TestEncodeErrorPath(t *testing.T) {
+	schema := RecordSchema{Name: "rec", Fields: []RecordField{
+		{Name: "pos", Schema: subrecordSchema},
+		{Name: "tags", Schema: ArraySchema{ItemSchema: String}},
+	}}
+	var w bytes.Buffer
+	err := schema.Encode(&w, Record{Schema: schema, Values: []interface{}{
+		Record{Schema: subrecordSchema, Values: []interface{}{true, "one"}},
+		[]interface{}{},
+	}})
+	assert.EqualError(t, err, "pos.l: ValueError. Expect long, found one of type string")
+	assert.Equal(t, []string{"pos", "l"}, err.(*PathError).Path)
+
+	w.Reset()
+	err = schema.Encode(&w, Record{Schema: schema, Values: []interface{}{
+		Record{Schema: subrecordSchema, Values: []interface{}{true, 1}},
+		[]interface{}{"a", 2},
+	}})
+	assert.Equal(t, []string{"tags", "[1]"}, err.(*PathError).Path)
+	assert.IsType(t, ValueError{}, errors.Unwrap(err))
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	schema := RecordSchema{Name: "rec", Fields: []RecordField{
+		{Name: "name", Schema: String},
+		{Name: "pos", Schema: subrecordSchema},
+	}}
+	_, err := schema.Decode(bytes.NewBuffer([]byte{6, 'o', 'n', 'e', 1}))
+	assert.EqualError(t, err, "pos.l: EOF")
+	assert.True(t, errors.Is(err, io.EOF))
+	_, err = schema.Decode(bytes.NewBuffer([]byte{6, 'o', 'n'}))
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+}
+
+func TestSafeEncodeDecode(t *testing.T) {
+	var w bytes.Buffer
+	assert.NoError(t, Encode(&w, Long, 5))
+	v, err := Decode(&w, Long)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, v)
+
+	assert.IsType(t, ValueError{}, Encode(&w, Long, "five"))
+	_, err = Decode(&w, Long)
+	assert.Equal(t, io.EOF, err)
+
+	// dangling reference panics, helpers turn it into error
+	broken := SchemaRef{Name: "broken", Target: new(Schema)}
+	assert.Error(t, Encode(&w, broken, 1))
+	_, err = Decode(bytes.NewBuffer([]byte{0}), broken)
+	assert.Error(t, err)
+}
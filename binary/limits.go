@@ -0,0 +1,68 @@
+package binary
+
+import (
+	"fmt"
+	. "github.com/galtsev/avro"
+	"io"
+)
+
+// Limits bound lengths read by decoders, so that hostile input can't make them
+// allocate unbounded memory. Zero fields mean no limit.
+type Limits struct {
+	// length of bytes and string values
+	MaxBytesLength int
+	// number of array items and map entries
+	MaxCollectionLength int
+}
+
+// LimitError reports length read from input which exceeds Limits.
+type LimitError struct {
+	// what is limited, like "bytes", "array" or "map"
+	Kind   string
+	Length int
+	Limit  int
+}
+
+func (err LimitError) Error() string {
+	return fmt.Sprintf("%s length %d exceeds limit %d", err.Kind, err.Length, err.Limit)
+}
+
+type limitedReader struct {
+	Reader
+	limits Limits
+}
+
+// WithLimits returns reader checking lengths of values decoded from r against limits.
+func WithLimits(r Reader, limits Limits) Reader {
+	if lr, ok := r.(limitedReader); ok {
+		r = lr.Reader
+	}
+	return limitedReader{Reader: r, limits: limits}
+}
+
+func checkLength(r Reader, kind string, n int) error {
+	lr, ok := r.(limitedReader)
+	if !ok {
+		return nil
+	}
+	limit := lr.limits.MaxCollectionLength
+	if kind == "bytes" {
+		limit = lr.limits.MaxBytesLength
+	}
+	if limit > 0 && n > limit {
+		return LimitError{Kind: kind, Length: n, Limit: limit}
+	}
+	return nil
+}
+
+// checkAvailable fails if r is known to hold less than n bytes,
+// as bytes.Buffer and bytes.Reader do, before allocating them
+func checkAvailable(r Reader, n int) error {
+	if lr, ok := r.(limitedReader); ok {
+		r = lr.Reader
+	}
+	if l, ok := r.(interface{ Len() int }); ok && n > l.Len() {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
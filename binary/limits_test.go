@@ -0,0 +1,47 @@
+package binary
+
+import (
+	"bytes"
+	. "github.com/galtsev/avro"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	limits := Limits{MaxBytesLength: 4, MaxCollectionLength: 3}
+	encode := func(schema Schema, v interface{}) *bytes.Buffer {
+		var buf bytes.Buffer
+		assert.NoError(t, schema.Encode(&buf, v))
+		return &buf
+	}
+
+	_, err := String.Decode(WithLimits(encode(String, "hello"), limits))
+	assert.Equal(t, LimitError{Kind: "bytes", Length: 5, Limit: 4}, err)
+	v, err := String.Decode(WithLimits(encode(String, "hell"), limits))
+	assert.NoError(t, err)
+	assert.Equal(t, "hell", v)
+
+	// limit applies to total of all blocks
+	array := ArraySchema{ItemSchema: Null, BlockSize: 2}
+	_, err = array.Decode(WithLimits(encode(array, []interface{}{nil, nil, nil, nil}), limits))
+	assert.Equal(t, LimitError{Kind: "array", Length: 4, Limit: 3}, err)
+	_, err = array.Decode(WithLimits(encode(array, []interface{}{nil, nil, nil}), limits))
+	assert.NoError(t, err)
+
+	m := MapSchema{ValueSchema: Null}
+	data := encode(m, map[string]interface{}{"a": nil, "b": nil, "c": nil, "d": nil})
+	_, err = m.Decode(WithLimits(data, limits))
+	assert.Equal(t, LimitError{Kind: "map", Length: 4, Limit: 3}, err)
+
+	var arr []string
+	err = Unmarshal(WithLimits(encode(ArraySchema{ItemSchema: String}, []interface{}{"a", "b", "c", "d"}), limits),
+		ArraySchema{ItemSchema: String}, &arr)
+	assert.Equal(t, LimitError{Kind: "array", Length: 4, Limit: 3}, err)
+
+	// without limits, length is checked against buffered data
+	var buf bytes.Buffer
+	EncodeVarInt(&buf, 1<<40)
+	_, err = DecodeBytes(&buf)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
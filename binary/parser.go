@@ -2,69 +2,421 @@ package binary
 
 import (
 	"encoding/json"
+	"fmt"
 	. "github.com/galtsev/avro"
+	"io"
+	"strconv"
+	"strings"
 )
 
 type BinarySchemaRepo struct {
 	schemas map[string]Schema
+	// records being parsed, by fullname; references to them are resolved through SchemaRef
+	pending map[string]*Schema
+	// named types defined by Append in progress, added to schemas only if it succeeds
+	staged map[string]Schema
 }
 
 func NewRepo() SchemaRepo {
-	repo := BinarySchemaRepo{schemas: make(map[string]Schema)}
+	repo := BinarySchemaRepo{schemas: make(map[string]Schema), pending: make(map[string]*Schema)}
 	repo.AppendSchema("null", Null)
 	repo.AppendSchema("boolean", Boolean)
 	repo.AppendSchema("int", Integer)
 	repo.AppendSchema("long", Long)
 	repo.AppendSchema("bytes", Bytes)
 	repo.AppendSchema("string", String)
+	repo.AppendSchema("float", Float)
 	repo.AppendSchema("double", Double)
 	return &repo
 }
 
-func (r *BinarySchemaRepo) buildField(schema interface{}) RecordField {
-	m := schema.(map[string]interface{})
-	return RecordField{Name: m["name"].(string), Schema: r.buildCodec(m["type"])}
+// SchemaError reports invalid JSON schema definition.
+type SchemaError struct {
+	Reason string
 }
 
-func (r *BinarySchemaRepo) buildCodec(schema interface{}) Schema {
+func (err SchemaError) Error() string {
+	return "invalid schema: " + err.Reason
+}
+
+func schemaErrorf(format string, args ...interface{}) error {
+	return SchemaError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// string attribute key of a JSON object
+func stringAttr(m map[string]interface{}, key string) (string, error) {
+	s, ok := m[key].(string)
+	if !ok {
+		return "", schemaErrorf("attribute %q should be a string, found %v", key, m[key])
+	}
+	return s, nil
+}
+
+// split the "name" and "namespace" attributes of a named type into short name and namespace.
+// A dotted name carries its own namespace, otherwise the enclosing one is inherited.
+func parseName(m map[string]interface{}, enclosing string) (name, namespace string, err error) {
+	if name, err = stringAttr(m, "name"); err != nil {
+		return
+	}
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[i+1:], name[:i], nil
+	}
+	if ns, ok := m["namespace"].(string); ok {
+		return name, ns, nil
+	}
+	return name, enclosing, nil
+}
+
+// names and enum symbols match [A-Za-z_][A-Za-z0-9_]*
+func isName(s string) bool {
+	for i, c := range s {
+		if !(c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// resolve type reference: short names are looked up in the current namespace first
+func (r *BinarySchemaRepo) lookup(name, namespace string) (Schema, error) {
+	for _, n := range []string{fullName(name, namespace), name} {
+		if schema, ok := r.schemas[n]; ok {
+			return schema, nil
+		}
+		if schema, ok := r.staged[n]; ok {
+			return schema, nil
+		}
+		if target, ok := r.pending[n]; ok {
+			return SchemaRef{Name: n, Target: target}, nil
+		}
+	}
+	return nil, schemaErrorf("unknown type %q", name)
+}
+
+func (r *BinarySchemaRepo) buildField(schema interface{}, namespace string) (RecordField, error) {
+	m, ok := schema.(map[string]interface{})
+	if !ok {
+		return RecordField{}, schemaErrorf("record field should be an object, found %v", schema)
+	}
+	name, err := stringAttr(m, "name")
+	if err != nil {
+		return RecordField{}, err
+	}
+	fieldSchema, err := r.buildCodec(m["type"], namespace)
+	if err != nil {
+		return RecordField{}, WithPath(err, name)
+	}
+	field := RecordField{Name: name, Schema: fieldSchema}
+	if def, ok := m["default"]; ok {
+		if field.Default, err = parseDefault(fieldSchema, def); err != nil {
+			return RecordField{}, WithPath(err, name)
+		}
+		field.HasDefault = true
+	}
+	return field, nil
+}
+
+// ParseBytes converts JSON string representation of bytes and fixed values,
+// with code points 0-255, to bytes. Returns false if s has other code points.
+func ParseBytes(s string) ([]byte, bool) {
+	buf := make([]byte, 0, len(s))
+	for _, c := range s {
+		if c > 255 {
+			return nil, false
+		}
+		buf = append(buf, byte(c))
+	}
+	return buf, true
+}
+
+// convert default value v decoded from JSON to the value of schema
+func parseDefault(schema Schema, v interface{}) (interface{}, error) {
+	invalid := schemaErrorf("invalid default %v for %s", v, schema.SchemaName())
+	switch s := deref(schema).(type) {
+	case nil:
+		return nil, schemaErrorf("default for %s can't be used before its definition is complete", schema.SchemaName())
+	case NullSchema:
+		if v != nil {
+			return nil, invalid
+		}
+		return nil, nil
+	case BooleanSchema:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case IntSchema:
+		if n, ok := v.(json.Number); ok {
+			if i, err := strconv.ParseInt(string(n), 10, 32); err == nil {
+				return int32(i), nil
+			}
+		}
+	case LongSchema:
+		if n, ok := v.(json.Number); ok {
+			if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+				return int(i), nil
+			}
+		}
+	case FloatSchema:
+		if n, ok := v.(json.Number); ok {
+			if f, err := strconv.ParseFloat(string(n), 32); err == nil {
+				return float32(f), nil
+			}
+		}
+	case DoubleSchema:
+		if n, ok := v.(json.Number); ok {
+			if f, err := n.Float64(); err == nil {
+				return f, nil
+			}
+		}
+	case StringSchema:
+		if str, ok := v.(string); ok {
+			return str, nil
+		}
+	case BytesSchema:
+		if str, ok := v.(string); ok {
+			if buf, ok := ParseBytes(str); ok {
+				return buf, nil
+			}
+		}
+	case FixedSchema:
+		if str, ok := v.(string); ok {
+			if buf, ok := ParseBytes(str); ok && len(buf) == s.Size {
+				return buf, nil
+			}
+		}
+	case EnumSchema:
+		if str, ok := v.(string); ok && s.SymbolIndex(str) >= 0 {
+			return str, nil
+		}
+	case ArraySchema:
+		if items, ok := v.([]interface{}); ok {
+			res := make([]interface{}, len(items))
+			for i, item := range items {
+				var err error
+				if res[i], err = parseDefault(s.ItemSchema, item); err != nil {
+					return nil, WithPath(err, IndexPath(i))
+				}
+			}
+			return res, nil
+		}
+	case MapSchema:
+		if m, ok := v.(map[string]interface{}); ok {
+			res := make(map[string]interface{}, len(m))
+			for key, value := range m {
+				var err error
+				if res[key], err = parseDefault(s.ValueSchema, value); err != nil {
+					return nil, WithPath(err, KeyPath(key))
+				}
+			}
+			return res, nil
+		}
+	case RecordSchema:
+		if m, ok := v.(map[string]interface{}); ok {
+			rec := Record{Schema: s, Values: make([]interface{}, len(s.Fields))}
+			for i, f := range s.Fields {
+				value, ok := m[f.Name]
+				if !ok {
+					if !f.HasDefault {
+						return nil, WithPath(schemaErrorf("missing value without default"), f.Name)
+					}
+					rec.Values[i] = f.Default
+					continue
+				}
+				var err error
+				if rec.Values[i], err = parseDefault(f.Schema, value); err != nil {
+					return nil, WithPath(err, f.Name)
+				}
+			}
+			return rec, nil
+		}
+	case UnionSchema:
+		// default of a union corresponds to its first branch
+		if len(s.Options) > 0 {
+			return parseDefault(s.Options[0], v)
+		}
+	}
+	return nil, invalid
+}
+
+// namespace is the namespace of the enclosing named type
+func (r *BinarySchemaRepo) buildCodec(schema interface{}, namespace string) (Schema, error) {
 	switch v := schema.(type) {
 	case string:
-		return r.Get(v)
+		return r.lookup(v, namespace)
 	case []interface{}:
 		var res UnionSchema
 		for _, t := range v {
-			res.Options = append(res.Options, r.buildCodec(t))
+			option, err := r.buildCodec(t, namespace)
+			if err != nil {
+				return nil, err
+			}
+			res.Options = append(res.Options, option)
 		}
-		return res
+		return res, nil
 	case map[string]interface{}:
-		switch v["type"].(string) {
+		typ, ok := v["type"].(string)
+		if !ok {
+			return r.buildCodec(v["type"], namespace)
+		}
+		switch typ {
 		case "fixed":
-			return FixedSchema{Name: v["name"].(string), Size: v["size"].(int)}
+			var res FixedSchema
+			var err error
+			if res.Name, res.Namespace, err = parseName(v, namespace); err != nil {
+				return nil, err
+			}
+			n, _ := v["size"].(json.Number)
+			size, err := strconv.ParseInt(string(n), 10, 0)
+			if err != nil || size < 0 {
+				return nil, schemaErrorf("fixed %s should have non-negative integer size, found %v", res.SchemaName(), v["size"])
+			}
+			res.Size = int(size)
+			if err = r.define(res.SchemaName(), res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		case "map":
+			values, err := r.buildCodec(v["values"], namespace)
+			if err != nil {
+				return nil, err
+			}
+			return MapSchema{ValueSchema: values}, nil
+		case "enum":
+			var res EnumSchema
+			var err error
+			if res.Name, res.Namespace, err = parseName(v, namespace); err != nil {
+				return nil, err
+			}
+			symbols, ok := v["symbols"].([]interface{})
+			if !ok {
+				return nil, schemaErrorf("enum %s should have symbols array", res.SchemaName())
+			}
+			for _, symbol := range symbols {
+				s, ok := symbol.(string)
+				if !ok {
+					return nil, schemaErrorf("enum %s symbols should be strings, found %v", res.SchemaName(), symbol)
+				}
+				if !isName(s) {
+					return nil, schemaErrorf("enum %s symbol %q is not a valid name", res.SchemaName(), s)
+				}
+				if res.SymbolIndex(s) >= 0 {
+					return nil, schemaErrorf("enum %s has duplicate symbol %s", res.SchemaName(), s)
+				}
+				res.Symbols = append(res.Symbols, s)
+			}
+			if d, ok := v["default"]; ok {
+				def, ok := d.(string)
+				if !ok {
+					return nil, schemaErrorf("enum %s default should be a string, found %v", res.SchemaName(), d)
+				}
+				if res.SymbolIndex(def) < 0 {
+					return nil, schemaErrorf("enum %s default %q is not a symbol", res.SchemaName(), def)
+				}
+				res.Default = def
+			}
+			if err = r.define(res.SchemaName(), res); err != nil {
+				return nil, err
+			}
+			return res, nil
 		case "array":
-			return ArraySchema{ItemSchema: r.buildCodec(v["items"])}
+			items, err := r.buildCodec(v["items"], namespace)
+			if err != nil {
+				return nil, err
+			}
+			return ArraySchema{ItemSchema: items}, nil
 		case "record":
 			var res RecordSchema
-			res.Name = v["name"].(string)
-			for _, f := range v["fields"].([]interface{}) {
-				res.Fields = append(res.Fields, r.buildField(f))
+			var err error
+			if res.Name, res.Namespace, err = parseName(v, namespace); err != nil {
+				return nil, err
+			}
+			name := res.SchemaName()
+			fields, ok := v["fields"].([]interface{})
+			if !ok {
+				return nil, schemaErrorf("record %s should have fields array", name)
+			}
+			if _, ok := r.pending[name]; ok {
+				return nil, redefinedError(name)
+			}
+			if r.defined(name) {
+				return nil, redefinedError(name)
 			}
-			return res
+			target := new(Schema)
+			r.pending[name] = target
+			defer delete(r.pending, name)
+			for _, f := range fields {
+				field, err := r.buildField(f, res.Namespace)
+				if err != nil {
+					return nil, err
+				}
+				res.Fields = append(res.Fields, field)
+			}
+			*target = res
+			if err = r.define(name, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		default:
+			return r.lookup(typ, namespace)
 		}
 	}
+	return nil, schemaErrorf("unexpected type definition %v", schema)
+}
+
+func redefinedError(name string) error {
+	return schemaErrorf("type %s is already defined", name)
+}
+
+func (r *BinarySchemaRepo) defined(name string) bool {
+	_, inRepo := r.schemas[name]
+	_, inStaged := r.staged[name]
+	return inRepo || inStaged
+}
+
+// define stages named type parsed by Append
+func (r *BinarySchemaRepo) define(name string, schema Schema) error {
+	if r.defined(name) {
+		return redefinedError(name)
+	}
+	r.staged[name] = schema
 	return nil
 }
 
-func (r *BinarySchemaRepo) AppendSchema(name string, schema Schema) {
+// AppendSchema registers schema under name, which must not be defined yet.
+func (r *BinarySchemaRepo) AppendSchema(name string, schema Schema) error {
+	if _, ok := r.schemas[name]; ok {
+		return redefinedError(name)
+	}
 	r.schemas[name] = schema
+	return nil
 }
 
-func (r *BinarySchemaRepo) Append(j string) Schema {
+func (r *BinarySchemaRepo) Append(j string) (Schema, error) {
 	var parsedSchema interface{}
-	check(json.Unmarshal([]byte(j), &parsedSchema))
-	schema := r.buildCodec(parsedSchema)
+	decoder := json.NewDecoder(strings.NewReader(j))
+	// keep precision of long defaults
+	decoder.UseNumber()
+	if err := decoder.Decode(&parsedSchema); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, schemaErrorf("unexpected data after schema")
+	}
+	// on failure, none of the types defined by j are registered
+	r.staged = make(map[string]Schema)
+	defer func() { r.staged = nil }()
+	schema, err := r.buildCodec(parsedSchema, "")
+	if err != nil {
+		return nil, err
+	}
+	for name, s := range r.staged {
+		r.schemas[name] = s
+	}
 	name := schema.SchemaName()
-	r.AppendSchema(name, schema)
-	return schema
+	if _, ok := r.schemas[name]; !ok {
+		r.schemas[name] = schema
+	}
+	return schema, nil
 }
 
 func (r *BinarySchemaRepo) Get(name string) Schema {
@@ -21,6 +21,11 @@ var (
 		schema Schema
 	}{
 		{`{"type": "array", "items": "string"}`, ArraySchema{ItemSchema: String}},
+		{`{"type": "map", "values": "long"}`, MapSchema{ValueSchema: Long}},
+		{
+			`{"type": "map", "values": {"type": "map", "values": ["null", {"type": "array", "items": "int"}]}}`,
+			MapSchema{ValueSchema: MapSchema{ValueSchema: UnionSchema{Options: []Schema{Null, ArraySchema{ItemSchema: Integer}}}}},
+		},
 		{`{
         "name": "example_3",
         "type": "record",
@@ -56,9 +61,9 @@ var (
     }`, RecordSchema{
 			Name: "example_4",
 			Fields: []RecordField{
-				{"id", Long},
-				{"flags", ArraySchema{ItemSchema: String}},
-				{"pos", subRecord},
+				{Name: "id", Schema: Long},
+				{Name: "flags", Schema: ArraySchema{ItemSchema: String}},
+				{Name: "pos", Schema: subRecord},
 			},
 		}},
 	}
@@ -67,7 +72,8 @@ var (
 func TestNewCodec(t *testing.T) {
 	repo := NewRepo()
 	for _, data := range parserData {
-		schema := repo.Append(data.j)
+		schema, err := repo.Append(data.j)
+		assert.NoError(t, err)
 		assert.Equal(t, data.schema, schema)
 	}
 }
@@ -89,16 +95,314 @@ var parserData2 = []struct {
         }`,
 		value: Record{Values: []interface{}{"dan", int32(14), false}},
 	},
+	{
+		j: `{
+            "name": "point",
+            "type": "record",
+            "fields": [
+                {"name": "x", "type": "float"},
+                {"name": "y", "type": ["null", "float"]}
+            ]
+        }`,
+		value: Record{Values: []interface{}{float32(0.5), float32(-2)}},
+	},
+	{
+		j: `{
+            "name": "inventory",
+            "type": "record",
+            "fields": [
+                {"name": "counts", "type": {"type": "map", "values": "long"}},
+                {"name": "tags", "type": ["null", {"type": "map", "values": "string"}]}
+            ]
+        }`,
+		value: Record{Values: []interface{}{
+			map[string]interface{}{"apples": 3, "pears": 0},
+			map[string]interface{}{"color": "red"},
+		}},
+	},
 }
 
 func TestParser(t *testing.T) {
 	repo := NewRepo()
 	for _, data := range parserData2 {
-		schema := repo.Append(data.j)
+		schema, err := repo.Append(data.j)
+		assert.NoError(t, err)
 		var w bytes.Buffer
-		schema.Encode(&w, data.value)
-		rec := schema.Decode(&w)
+		assert.NoError(t, schema.Encode(&w, data.value))
+		rec, err := schema.Decode(&w)
+		assert.NoError(t, err)
 		data.value.Schema = schema
 		assert.Equal(t, data.value, rec)
 	}
 }
+
+func TestParseEnum(t *testing.T) {
+	repo := NewRepo()
+	schema, err := repo.Append(`{
+        "name": "card",
+        "type": "record",
+        "fields": [
+            {"name": "suit", "type": {"type": "enum", "name": "Suit", "symbols": ["SPADES", "HEARTS"], "default": "HEARTS"}},
+            {"name": "trump", "type": "Suit"}
+        ]
+    }`)
+	assert.NoError(t, err)
+	suit := EnumSchema{Name: "Suit", Symbols: []string{"SPADES", "HEARTS"}, Default: "HEARTS"}
+	assert.Equal(t, suit, repo.Get("Suit"))
+	assert.Equal(t, RecordSchema{Name: "card", Fields: []RecordField{{Name: "suit", Schema: suit}, {Name: "trump", Schema: suit}}}, schema)
+}
+
+func TestParseEnumSymbols(t *testing.T) {
+	for j, msg := range map[string]string{
+		`{"type": "enum", "name": "e"}`:                             "enum e should have symbols array",
+		`{"type": "enum", "name": "e", "symbols": "A"}`:             "enum e should have symbols array",
+		`{"type": "enum", "name": "e", "symbols": ["A", 1]}`:        "enum e symbols should be strings, found 1",
+		`{"type": "enum", "name": "e", "symbols": ["A", "B", "A"]}`: "enum e has duplicate symbol A",
+		`{"type": "enum", "name": "e", "symbols": ["A", "1B"]}`:     `enum e symbol "1B" is not a valid name`,
+		`{"type": "enum", "name": "e", "symbols": ["A-B"]}`:         `enum e symbol "A-B" is not a valid name`,
+		`{"type": "enum", "name": "e", "symbols": [""]}`:            `enum e symbol "" is not a valid name`,
+	} {
+		_, err := NewRepo().Append(j)
+		assert.IsType(t, SchemaError{}, err, j)
+		assert.EqualError(t, err, "invalid schema: "+msg, j)
+	}
+	_, err := NewRepo().Append(`{"type": "enum", "name": "e", "symbols": ["_a", "B9", "c_D"]}`)
+	assert.NoError(t, err)
+}
+
+func TestParseEnumBadDefault(t *testing.T) {
+	repo := NewRepo()
+	_, err := repo.Append(`{"type": "enum", "name": "Suit", "symbols": ["SPADES"], "default": "JOKER"}`)
+	assert.IsType(t, SchemaError{}, err)
+	assert.EqualError(t, err, `invalid schema: enum Suit default "JOKER" is not a symbol`)
+	_, err = repo.Append(`{"type": "enum", "name": "Suit", "symbols": ["SPADES"], "default": 0}`)
+	assert.EqualError(t, err, "invalid schema: enum Suit default should be a string, found 0")
+}
+
+func TestParseFixedSize(t *testing.T) {
+	for _, size := range []string{`2.5`, `-1`, `"2"`} {
+		_, err := NewRepo().Append(`{"type": "fixed", "name": "f", "size": ` + size + `}`)
+		assert.IsType(t, SchemaError{}, err, size)
+	}
+	_, err := NewRepo().Append(`"int" "long"`)
+	assert.IsType(t, SchemaError{}, err)
+}
+
+func TestParseNamespaces(t *testing.T) {
+	repo := NewRepo()
+	schema, err := repo.Append(`{
+        "name": "Person",
+        "namespace": "com.example.people",
+        "type": "record",
+        "fields": [
+            {"name": "id", "type": {"type": "fixed", "name": "Id", "size": 4}},
+            {"name": "kind", "type": {"type": "enum", "name": "Kind", "symbols": ["A", "B"]}},
+            {"name": "alias", "type": {"type": "enum", "name": "com.example.other.Kind", "symbols": ["X"]}},
+            {"name": "home", "type": {"type": "enum", "name": "Kind", "namespace": "com.example.places", "symbols": ["Y"]}},
+            {"name": "again", "type": "Kind"},
+            {"name": "again_full", "type": "com.example.other.Kind"}
+        ]
+    }`)
+	assert.NoError(t, err)
+	record := schema.(RecordSchema)
+	assert.Equal(t, "com.example.people.Person", schema.SchemaName())
+	names := []string{
+		"com.example.people.Id",
+		"com.example.people.Kind",
+		"com.example.other.Kind",
+		"com.example.places.Kind",
+		"com.example.people.Kind",
+		"com.example.other.Kind",
+	}
+	for i, name := range names {
+		assert.Equal(t, name, record.Fields[i].Schema.SchemaName(), record.Fields[i].Name)
+	}
+	assert.Equal(t, []string{"A", "B"}, repo.Get("com.example.people.Kind").(EnumSchema).Symbols)
+	assert.Equal(t, []string{"X"}, repo.Get("com.example.other.Kind").(EnumSchema).Symbols)
+	assert.Equal(t, schema, repo.Get("com.example.people.Person"))
+}
+
+func TestParseNamedReferences(t *testing.T) {
+	repo := NewRepo()
+	schema, err := repo.Append(`{
+        "name": "segment",
+        "type": "record",
+        "fields": [
+            {"name": "start", "type": {"type": "record", "name": "subrecord", "fields": [
+                {"name": "x", "type": "long"},
+                {"name": "y", "type": "long"}
+            ]}},
+            {"name": "end", "type": "subrecord"},
+            {"name": "hash", "type": {"type": "fixed", "name": "md5", "size": 16}},
+            {"name": "parent_hash", "type": "md5"}
+        ]
+    }`)
+	assert.NoError(t, err)
+	assert.Equal(t, subRecord, repo.Get("subrecord"))
+	assert.Equal(t, FixedSchema{Name: "md5", Size: 16}, repo.Get("md5"))
+	fields := schema.(RecordSchema).Fields
+	assert.Equal(t, subRecord, fields[1].Schema)
+	assert.Equal(t, FixedSchema{Name: "md5", Size: 16}, fields[3].Schema)
+}
+
+func TestParseRecursive(t *testing.T) {
+	repo := NewRepo()
+	schema, err := repo.Append(`{
+        "name": "Node",
+        "type": "record",
+        "fields": [
+            {"name": "label", "type": "string"},
+            {"name": "children", "type": {"type": "array", "items": "Node"}},
+            {"name": "next", "type": ["null", "Node"]}
+        ]
+    }`)
+	assert.NoError(t, err)
+	leaf := func(label string) Record {
+		return Record{Schema: schema, Values: []interface{}{label, []interface{}{}, nil}}
+	}
+	tree := Record{Schema: schema, Values: []interface{}{
+		"root",
+		[]interface{}{leaf("a"), Record{Schema: schema, Values: []interface{}{"b", []interface{}{leaf("c")}, leaf("d")}}},
+		nil,
+	}}
+	var w bytes.Buffer
+	assert.NoError(t, schema.Encode(&w, tree))
+	v, err := schema.Decode(&w)
+	assert.NoError(t, err)
+	assert.Equal(t, tree, v)
+	assert.Equal(t, 0, w.Len())
+}
+
+func TestParseErrors(t *testing.T) {
+	repo := NewRepo()
+	_, err := repo.Append(`{"type": "array", "items": "nosuchtype"}`)
+	assert.EqualError(t, err, `invalid schema: unknown type "nosuchtype"`)
+	_, err = repo.Append(`{"type": "record", "name": "r", "fields": [{"name": "f", "type": {"type": "fixed", "name": "x"}}]}`)
+	assert.EqualError(t, err, "f: invalid schema: fixed x should have non-negative integer size, found <nil>")
+	_, err = repo.Append(`{"type": "record"`)
+	assert.Error(t, err)
+	assert.Nil(t, repo.Get("r"))
+}
+
+func TestParseRedefinition(t *testing.T) {
+	repo := NewRepo()
+	_, err := repo.Append(`{"type": "fixed", "name": "ns.f", "size": 4}`)
+	assert.NoError(t, err)
+	for _, j := range []string{
+		`{"type": "enum", "name": "f", "namespace": "ns", "symbols": ["A"]}`,
+		`{"type": "record", "name": "ns.f", "fields": []}`,
+		`{"type": "record", "name": "r", "fields": [{"name": "a", "type": {"type": "record", "name": "r", "fields": []}}]}`,
+		`{"type": "record", "name": "p", "fields": [{"name": "a", "type": {"type": "fixed", "name": "p", "size": 1}}]}`,
+	} {
+		_, err = repo.Append(j)
+		assert.ErrorAs(t, err, &SchemaError{}, j)
+		assert.Contains(t, err.Error(), "is already defined", j)
+	}
+	assert.Equal(t, 4, repo.Get("ns.f").(FixedSchema).Size)
+	// appending a reference to defined type is fine
+	_, err = repo.Append(`"ns.f"`)
+	assert.NoError(t, err)
+	assert.EqualError(t, repo.AppendSchema("int", Long), "invalid schema: type int is already defined")
+}
+
+func TestParseRetry(t *testing.T) {
+	repo := NewRepo()
+	// nested types parsed before the error are dropped with the failed schema
+	_, err := repo.Append(`{"type": "record", "name": "r", "fields": [
+		{"name": "f", "type": {"type": "fixed", "name": "F", "size": 2}},
+		{"name": "e", "type": {"type": "enum", "name": "E", "symbols": ["A"]}},
+		{"name": "bad", "type": "unknown"}]}`)
+	assert.Error(t, err)
+	assert.Nil(t, repo.Get("F"))
+	assert.Nil(t, repo.Get("E"))
+	assert.Nil(t, repo.Get("r"))
+	schema, err := repo.Append(`{"type": "record", "name": "r", "fields": [
+		{"name": "f", "type": {"type": "fixed", "name": "F", "size": 2}},
+		{"name": "e", "type": {"type": "enum", "name": "E", "symbols": ["A"]}},
+		{"name": "ok", "type": "F"}]}`)
+	assert.NoError(t, err)
+	assert.Equal(t, schema, repo.Get("r"))
+	assert.Equal(t, 2, repo.Get("F").(FixedSchema).Size)
+}
+
+func TestParseDefaults(t *testing.T) {
+	repo := NewRepo()
+	schema, err := repo.Append(`{
+        "name": "defaults",
+        "type": "record",
+        "fields": [
+            {"name": "n", "type": "null", "default": null},
+            {"name": "b", "type": "boolean", "default": true},
+            {"name": "i", "type": "int", "default": -3},
+            {"name": "l", "type": "long", "default": 9007199254740993},
+            {"name": "f", "type": "float", "default": 0.5},
+            {"name": "d", "type": "double", "default": 2.5},
+            {"name": "s", "type": "string", "default": "ok"},
+            {"name": "bytes", "type": "bytes", "default": "ÿ\u0000A"},
+            {"name": "fixed", "type": {"type": "fixed", "name": "two", "size": 2}, "default": "ab"},
+            {"name": "e", "type": {"type": "enum", "name": "e", "symbols": ["X", "Y"]}, "default": "Y"},
+            {"name": "a", "type": {"type": "array", "items": "int"}, "default": [1, 2]},
+            {"name": "m", "type": {"type": "map", "values": "string"}, "default": {"k": "v"}},
+            {"name": "u", "type": ["null", "string"], "default": null},
+            {"name": "r", "type": {"type": "record", "name": "point", "fields": [
+                {"name": "x", "type": "long"},
+                {"name": "y", "type": "long", "default": 0}
+            ]}, "default": {"x": 1}},
+            {"name": "none", "type": "long"}
+        ]
+    }`)
+	assert.NoError(t, err)
+	point := repo.Get("point")
+	expected := []interface{}{
+		nil, true, int32(-3), 9007199254740993, float32(0.5), 2.5, "ok", []byte{0xFF, 0, 'A'}, []byte("ab"), "Y",
+		[]interface{}{int32(1), int32(2)}, map[string]interface{}{"k": "v"}, nil,
+		Record{Schema: point, Values: []interface{}{1, 0}},
+	}
+	fields := schema.(RecordSchema).Fields
+	for i, v := range expected {
+		assert.True(t, fields[i].HasDefault, fields[i].Name)
+		assert.Equal(t, v, fields[i].Default, fields[i].Name)
+	}
+	assert.False(t, fields[len(fields)-1].HasDefault)
+}
+
+func TestParseInvalidDefaults(t *testing.T) {
+	data := []struct {
+		typ string
+		def string
+		err string
+	}{
+		{`"int"`, `1.5`, "f: invalid schema: invalid default 1.5 for int"},
+		{`"int"`, `"1"`, "f: invalid schema: invalid default 1 for int"},
+		{`"int"`, `2147483648`, "f: invalid schema: invalid default 2147483648 for int"},
+		{`"long"`, `1e3`, "f: invalid schema: invalid default 1e3 for long"},
+		{`"bytes"`, `"Ā"`, "f: invalid schema: invalid default Ā for bytes"},
+		{`["null", "string"]`, `"a"`, "f: invalid schema: invalid default a for null"},
+		{`{"type": "array", "items": "long"}`, `[1, "a"]`, "f[1]: invalid schema: invalid default a for long"},
+		{`{"type": "enum", "name": "e", "symbols": ["A"]}`, `"B"`, "f: invalid schema: invalid default B for e"},
+	}
+	for _, d := range data {
+		_, err := NewRepo().Append(`{"type": "record", "name": "r", "fields": [{"name": "f", "type": ` + d.typ + `, "default": ` + d.def + `}]}`)
+		assert.EqualError(t, err, d.err, d.typ)
+	}
+}
+
+func TestEncodeMissingDefaults(t *testing.T) {
+	repo := NewRepo()
+	schema, err := repo.Append(`{
+        "name": "r",
+        "type": "record",
+        "fields": [
+            {"name": "id", "type": "long"},
+            {"name": "name", "type": "string", "default": "anonymous"},
+            {"name": "tags", "type": {"type": "array", "items": "string"}, "default": []}
+        ]
+    }`)
+	assert.NoError(t, err)
+	var w bytes.Buffer
+	assert.NoError(t, schema.Encode(&w, Record{Schema: schema, Values: []interface{}{5}}))
+	v, err := schema.Decode(&w)
+	assert.NoError(t, err)
+	assert.Equal(t, Record{Schema: schema, Values: []interface{}{5, "anonymous", []interface{}{}}}, v)
+	assert.Error(t, schema.Encode(&w, Record{Schema: schema, Values: []interface{}{}}))
+}
@@ -0,0 +1,607 @@
+package binary
+
+import (
+	"fmt"
+	. "github.com/galtsev/avro"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// BindError reports Go type which can't be bound to schema.
+type BindError struct {
+	Type   reflect.Type
+	Schema string
+	Reason string
+}
+
+func (err BindError) Error() string {
+	return fmt.Sprintf("can't bind %s to %s: %s", err.Type, err.Schema, err.Reason)
+}
+
+func bindError(t reflect.Type, schema Schema, reason string) error {
+	return BindError{Type: t, Schema: schema.SchemaName(), Reason: reason}
+}
+
+// reflectCodec encodes and decodes Go values of one type with one schema.
+// Decode is given addressable value to set.
+type reflectCodec struct {
+	encode func(w io.Writer, v reflect.Value) error
+	decode func(r Reader, v reflect.Value) error
+}
+
+type planKey struct {
+	typ    reflect.Type
+	schema string
+}
+
+// plans by type and schema, schema identified by its canonical form
+// with field defaults and block sizes
+var plans sync.Map
+
+func cachedPlan(t reflect.Type, schema Schema) (*reflectCodec, error) {
+	form, err := canonicalForm(schema, true)
+	if err != nil {
+		// schemas without canonical form, like resolved ones, can't be bound anyway
+		form = schema.String()
+	}
+	key := planKey{typ: t, schema: form}
+	if plan, ok := plans.Load(key); ok {
+		return plan.(*reflectCodec), nil
+	}
+	b := planBuilder{records: make(map[planKey]*reflectCodec)}
+	plan, err := b.build(t, schema)
+	if err != nil {
+		return nil, err
+	}
+	plans.Store(key, plan)
+	return plan, nil
+}
+
+// Binding encodes and decodes values of one Go type with one schema.
+// Marshal and Unmarshal look the plan up by schema on every call,
+// Binding does it once, which matters for small values.
+type Binding struct {
+	typ  reflect.Type
+	plan *reflectCodec
+}
+
+// Bind prepares encoding and decoding of values of type t with schema, bound as in Marshal.
+func Bind(t reflect.Type, schema Schema) (*Binding, error) {
+	plan, err := cachedPlan(t, schema)
+	if err != nil {
+		return nil, err
+	}
+	return &Binding{typ: t, plan: plan}, nil
+}
+
+// Marshal writes v, which must be of the bound type.
+func (b *Binding) Marshal(w io.Writer, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() || rv.Type() != b.typ {
+		return ValueError{Value: v, ExpectedType: b.typ.String()}
+	}
+	return b.plan.encode(w, rv)
+}
+
+// Unmarshal reads a value into v, which must be non-nil pointer to the bound type.
+func (b *Binding) Unmarshal(r Reader, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Type().Elem() != b.typ {
+		return ValueError{Value: v, ExpectedType: "*" + b.typ.String()}
+	}
+	return b.plan.decode(r, rv.Elem())
+}
+
+// Marshal writes Go value v with schema. Structs are bound to records by field name,
+// which can be overridden with `avro:"name"` tag, or skipped with `avro:"-"`.
+// Pointers are bound to ["null", T] unions, slices to arrays, map[string]T to maps
+// and [N]byte to fixed. Go int kinds can be written as int or long, string as enum.
+func Marshal(w io.Writer, schema Schema, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return ValueError{Value: v, ExpectedType: schema.SchemaName()}
+	}
+	plan, err := cachedPlan(rv.Type(), schema)
+	if err != nil {
+		return err
+	}
+	return plan.encode(w, rv)
+}
+
+// Unmarshal reads a value of schema into Go value pointed to by v, bound as in Marshal.
+func Unmarshal(r Reader, schema Schema, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ValueError{Value: v, ExpectedType: "non-nil pointer"}
+	}
+	plan, err := cachedPlan(rv.Type().Elem(), schema)
+	if err != nil {
+		return err
+	}
+	return plan.decode(r, rv.Elem())
+}
+
+type planBuilder struct {
+	// record plans being built, to terminate recursive types
+	records map[planKey]*reflectCodec
+}
+
+var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+func isIntKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isUintKind(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uintptr
+}
+
+// generic codec, for interface{} values
+func valueCodec(schema Schema) *reflectCodec {
+	return &reflectCodec{
+		encode: func(w io.Writer, v reflect.Value) error {
+			return schema.Encode(w, v.Interface())
+		},
+		decode: func(r Reader, v reflect.Value) error {
+			value, err := schema.Decode(r)
+			if err != nil {
+				return err
+			}
+			if value == nil {
+				v.Set(reflect.Zero(v.Type()))
+			} else {
+				v.Set(reflect.ValueOf(value))
+			}
+			return nil
+		},
+	}
+}
+
+// codec converting Go value to the native value of schema and back
+func convertCodec(schema Schema, toNative func(v reflect.Value) (interface{}, error), fromNative func(native interface{}, v reflect.Value) error) *reflectCodec {
+	return &reflectCodec{
+		encode: func(w io.Writer, v reflect.Value) error {
+			native, err := toNative(v)
+			if err != nil {
+				return err
+			}
+			return schema.Encode(w, native)
+		},
+		decode: func(r Reader, v reflect.Value) error {
+			native, err := schema.Decode(r)
+			if err != nil {
+				return err
+			}
+			return fromNative(native, v)
+		},
+	}
+}
+
+// integer of any Go int kind as int or long
+func intCodec(t reflect.Type, schema Schema, bits int) *reflectCodec {
+	min, max := int64(-1)<<(bits-1), int64(1)<<(bits-1)-1
+	toNative := func(v reflect.Value) (interface{}, error) {
+		var i int64
+		if isUintKind(v.Kind()) {
+			if v.Uint() > uint64(max) {
+				return nil, ValueError{Value: v.Interface(), ExpectedType: schema.SchemaName()}
+			}
+			i = int64(v.Uint())
+		} else {
+			i = v.Int()
+		}
+		if i < min || i > max {
+			return nil, ValueError{Value: v.Interface(), ExpectedType: schema.SchemaName()}
+		}
+		if bits == 32 {
+			return int32(i), nil
+		}
+		return int(i), nil
+	}
+	fromNative := func(native interface{}, v reflect.Value) error {
+		var i int64
+		if bits == 32 {
+			i = int64(native.(int32))
+		} else {
+			i = int64(native.(int))
+		}
+		if isUintKind(v.Kind()) {
+			if i < 0 || v.OverflowUint(uint64(i)) {
+				return ValueError{Value: native, ExpectedType: t.String()}
+			}
+			v.SetUint(uint64(i))
+			return nil
+		}
+		if v.OverflowInt(i) {
+			return ValueError{Value: native, ExpectedType: t.String()}
+		}
+		v.SetInt(i)
+		return nil
+	}
+	return convertCodec(schema, toNative, fromNative)
+}
+
+func (b *planBuilder) build(t reflect.Type, schema Schema) (*reflectCodec, error) {
+	if t == interfaceType {
+		return valueCodec(schema), nil
+	}
+	schema = deref(schema)
+	k := t.Kind()
+	if _, isUnion := schema.(UnionSchema); k == reflect.Ptr && !isUnion {
+		return b.buildPtr(t, schema)
+	}
+	switch s := schema.(type) {
+	case NullSchema:
+		return &reflectCodec{
+			encode: func(w io.Writer, v reflect.Value) error { return nil },
+			decode: func(r Reader, v reflect.Value) error {
+				v.Set(reflect.Zero(v.Type()))
+				return nil
+			},
+		}, nil
+	case BooleanSchema:
+		if k == reflect.Bool {
+			return convertCodec(schema,
+				func(v reflect.Value) (interface{}, error) { return v.Bool(), nil },
+				func(native interface{}, v reflect.Value) error {
+					v.SetBool(native.(bool))
+					return nil
+				}), nil
+		}
+	case IntSchema:
+		if isIntKind(k) || isUintKind(k) {
+			return intCodec(t, schema, 32), nil
+		}
+	case LongSchema:
+		if isIntKind(k) || isUintKind(k) {
+			return intCodec(t, schema, 64), nil
+		}
+	case FloatSchema, DoubleSchema:
+		if k == reflect.Float32 || k == reflect.Float64 {
+			_, isFloat := s.(FloatSchema)
+			return convertCodec(schema,
+				func(v reflect.Value) (interface{}, error) {
+					if isFloat {
+						return float32(v.Float()), nil
+					}
+					return v.Float(), nil
+				},
+				func(native interface{}, v reflect.Value) error {
+					if isFloat {
+						v.SetFloat(float64(native.(float32)))
+					} else {
+						v.SetFloat(native.(float64))
+					}
+					return nil
+				}), nil
+		}
+	case StringSchema, EnumSchema:
+		if k == reflect.String {
+			return convertCodec(schema,
+				func(v reflect.Value) (interface{}, error) { return v.String(), nil },
+				func(native interface{}, v reflect.Value) error {
+					v.SetString(native.(string))
+					return nil
+				}), nil
+		}
+	case BytesSchema:
+		if k == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return convertCodec(schema,
+				func(v reflect.Value) (interface{}, error) { return v.Bytes(), nil },
+				func(native interface{}, v reflect.Value) error {
+					v.SetBytes(native.([]byte))
+					return nil
+				}), nil
+		}
+	case FixedSchema:
+		if k == reflect.Array && t.Elem().Kind() == reflect.Uint8 {
+			if t.Len() != s.Size {
+				return nil, bindError(t, schema, fmt.Sprintf("size %d differs from %d", t.Len(), s.Size))
+			}
+			return convertCodec(schema,
+				func(v reflect.Value) (interface{}, error) {
+					buf := make([]byte, v.Len())
+					reflect.Copy(reflect.ValueOf(buf), v)
+					return buf, nil
+				},
+				func(native interface{}, v reflect.Value) error {
+					reflect.Copy(v, reflect.ValueOf(native))
+					return nil
+				}), nil
+		}
+	case ArraySchema:
+		if k == reflect.Slice || k == reflect.Array {
+			return b.buildArray(t, s)
+		}
+	case MapSchema:
+		if k == reflect.Map && t.Key().Kind() == reflect.String {
+			return b.buildMap(t, s)
+		}
+	case RecordSchema:
+		if k == reflect.Struct {
+			return b.buildRecord(t, s)
+		}
+	case UnionSchema:
+		return b.buildUnion(t, s)
+	}
+	return nil, bindError(t, schema, "incompatible types")
+}
+
+// pointer bound to non-union schema can't be nil
+func (b *planBuilder) buildPtr(t reflect.Type, schema Schema) (*reflectCodec, error) {
+	elem, err := b.build(t.Elem(), schema)
+	if err != nil {
+		return nil, err
+	}
+	encode := func(w io.Writer, v reflect.Value) error {
+		if v.IsNil() {
+			return ValueError{Value: nil, ExpectedType: schema.SchemaName()}
+		}
+		return elem.encode(w, v.Elem())
+	}
+	decode := func(r Reader, v reflect.Value) error {
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return elem.decode(r, v.Elem())
+	}
+	return &reflectCodec{encode: encode, decode: decode}, nil
+}
+
+func (b *planBuilder) buildArray(t reflect.Type, schema ArraySchema) (*reflectCodec, error) {
+	item, err := b.build(t.Elem(), schema.ItemSchema)
+	if err != nil {
+		return nil, err
+	}
+	encode := func(w io.Writer, v reflect.Value) error {
+		i := 0
+		for _, size := range blockSizes(v.Len(), schema.BlockSize) {
+			if err := EncodeVarInt(w, size); err != nil {
+				return err
+			}
+			for end := i + size; i < end; i++ {
+				if err := item.encode(w, v.Index(i)); err != nil {
+					return WithPath(err, IndexPath(i))
+				}
+			}
+		}
+		return EncodeVarInt(w, 0)
+	}
+	decode := func(r Reader, v reflect.Value) error {
+		if t.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(t, 0, 0))
+		}
+		for i := 0; ; {
+			count, err := DecodeBlockCount(r)
+			if err != nil || count == 0 {
+				return err
+			}
+			if err = checkLength(r, "array", i+count); err != nil {
+				return err
+			}
+			for end := i + count; i < end; i++ {
+				if t.Kind() == reflect.Slice {
+					v.Set(reflect.Append(v, reflect.Zero(t.Elem())))
+				} else if i >= v.Len() {
+					return ValueError{Value: i + 1, ExpectedType: fmt.Sprintf("at most %d items", v.Len())}
+				}
+				if err := item.decode(r, v.Index(i)); err != nil {
+					return WithPath(err, IndexPath(i))
+				}
+			}
+		}
+	}
+	return &reflectCodec{encode: encode, decode: decode}, nil
+}
+
+func (b *planBuilder) buildMap(t reflect.Type, schema MapSchema) (*reflectCodec, error) {
+	value, err := b.build(t.Elem(), schema.ValueSchema)
+	if err != nil {
+		return nil, err
+	}
+	encode := func(w io.Writer, v reflect.Value) error {
+		if n := v.Len(); n > 0 {
+			if err := EncodeVarInt(w, n); err != nil {
+				return err
+			}
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			key := iter.Key().String()
+			if err := EncodeString(w, key); err != nil {
+				return err
+			}
+			if err := value.encode(w, iter.Value()); err != nil {
+				return WithPath(err, KeyPath(key))
+			}
+		}
+		return EncodeVarInt(w, 0)
+	}
+	decode := func(r Reader, v reflect.Value) error {
+		v.Set(reflect.MakeMap(t))
+		elem := reflect.New(t.Elem()).Elem()
+		for {
+			count, err := DecodeBlockCount(r)
+			if err != nil || count == 0 {
+				return err
+			}
+			if err = checkLength(r, "map", v.Len()+count); err != nil {
+				return err
+			}
+			for i := 0; i < count; i++ {
+				key, err := DecodeBytes(r)
+				if err != nil {
+					return err
+				}
+				elem.Set(reflect.Zero(t.Elem()))
+				if err := value.decode(r, elem); err != nil {
+					return WithPath(err, KeyPath(string(key)))
+				}
+				v.SetMapIndex(reflect.ValueOf(string(key)).Convert(t.Key()), elem)
+			}
+		}
+	}
+	return &reflectCodec{encode: encode, decode: decode}, nil
+}
+
+// name of the record field struct field is bound to, empty if the field is skipped
+func structFieldName(f reflect.StructField) string {
+	if f.PkgPath != "" {
+		return ""
+	}
+	tag := f.Tag.Get("avro")
+	if tag == "-" {
+		return ""
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name
+	}
+	return f.Name
+}
+
+// index of the struct field bound to record field name, -1 if there is none.
+// Tagged names must match exactly, field names case-insensitively.
+func structFieldIndex(t reflect.Type, name string) int {
+	index := -1
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fieldName := structFieldName(f)
+		if fieldName == name {
+			return i
+		}
+		if f.Tag.Get("avro") == "" && index < 0 && strings.EqualFold(fieldName, name) {
+			index = i
+		}
+	}
+	return index
+}
+
+func (b *planBuilder) buildRecord(t reflect.Type, schema RecordSchema) (*reflectCodec, error) {
+	key := planKey{typ: t, schema: schema.SchemaName()}
+	if plan, ok := b.records[key]; ok {
+		return plan, nil
+	}
+	plan := new(reflectCodec)
+	b.records[key] = plan
+
+	// struct field index and codec of each record field, index -1 if struct has no such field
+	indexes := make([]int, len(schema.Fields))
+	codecs := make([]*reflectCodec, len(schema.Fields))
+	for i, f := range schema.Fields {
+		indexes[i] = structFieldIndex(t, f.Name)
+		if indexes[i] < 0 {
+			continue
+		}
+		var err error
+		if codecs[i], err = b.build(t.Field(indexes[i]).Type, f.Schema); err != nil {
+			delete(b.records, key)
+			return nil, WithPath(err, f.Name)
+		}
+	}
+	plan.encode = func(w io.Writer, v reflect.Value) error {
+		for i, f := range schema.Fields {
+			var err error
+			switch {
+			case indexes[i] >= 0:
+				err = codecs[i].encode(w, v.Field(indexes[i]))
+			case f.HasDefault:
+				err = f.Schema.Encode(w, f.Default)
+			default:
+				err = fmt.Errorf("no struct field and no default")
+			}
+			if err != nil {
+				return WithPath(err, f.Name)
+			}
+		}
+		return nil
+	}
+	plan.decode = func(r Reader, v reflect.Value) error {
+		for i, f := range schema.Fields {
+			var err error
+			if indexes[i] >= 0 {
+				err = codecs[i].decode(r, v.Field(indexes[i]))
+			} else {
+				_, err = f.Schema.Decode(r)
+			}
+			if err != nil {
+				return WithPath(err, f.Name)
+			}
+		}
+		return nil
+	}
+	return plan, nil
+}
+
+// Pointers are bound to union null branch when nil, and to the first branch matching
+// the pointed type otherwise. Other types are bound to the first matching branch on
+// encoding, and set to zero value when null branch is decoded.
+func (b *planBuilder) buildUnion(t reflect.Type, schema UnionSchema) (*reflectCodec, error) {
+	nullIndex := -1
+	valueIndex := -1
+	elemType := t
+	if t.Kind() == reflect.Ptr {
+		elemType = t.Elem()
+	}
+	codecs := make([]*reflectCodec, len(schema.Options))
+	for i, option := range schema.Options {
+		if _, ok := deref(option).(NullSchema); ok {
+			if nullIndex < 0 {
+				nullIndex = i
+			}
+			continue
+		}
+		codec, err := b.build(elemType, option)
+		if err != nil {
+			continue
+		}
+		codecs[i] = codec
+		if valueIndex < 0 {
+			valueIndex = i
+		}
+	}
+	if valueIndex < 0 {
+		return nil, bindError(t, schema, "no matching union branch")
+	}
+	isPtr := t.Kind() == reflect.Ptr
+	encode := func(w io.Writer, v reflect.Value) error {
+		if isPtr {
+			if v.IsNil() {
+				if nullIndex < 0 {
+					return ValueError{Value: nil, ExpectedType: schema.String()}
+				}
+				return EncodeVarInt(w, nullIndex)
+			}
+			v = v.Elem()
+		}
+		if err := EncodeVarInt(w, valueIndex); err != nil {
+			return err
+		}
+		return codecs[valueIndex].encode(w, v)
+	}
+	decode := func(r Reader, v reflect.Value) error {
+		ind, err := DecodeVarInt(r)
+		if err != nil {
+			return err
+		}
+		if ind < 0 || ind >= len(codecs) {
+			return ValueError{Value: ind, ExpectedType: fmt.Sprintf("union index below %d", len(codecs))}
+		}
+		if ind == nullIndex {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		if codecs[ind] == nil {
+			return bindError(t, schema.Options[ind], "incompatible union branch in data")
+		}
+		if isPtr {
+			if v.IsNil() {
+				v.Set(reflect.New(elemType))
+			}
+			v = v.Elem()
+		}
+		return codecs[ind].decode(r, v)
+	}
+	return &reflectCodec{encode: encode, decode: decode}, nil
+}
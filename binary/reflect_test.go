@@ -0,0 +1,194 @@
+package binary
+
+import (
+	"bytes"
+	. "github.com/galtsev/avro"
+	"github.com/stretchr/testify/assert"
+
+	"reflect"
+	"testing"
+)
+
+type point struct {
+	X int64
+	Y int64
+}
+
+type user struct {
+	ID       int32             `avro:"id"`
+	Name     string            `avro:"name"`
+	Email    *string           `avro:"email"`
+	Scores   []float64         `avro:"scores"`
+	Labels   map[string]uint16 `avro:"labels"`
+	Hash     [4]byte           `avro:"hash"`
+	Kind     string            `avro:"kind"`
+	Home     *point            `avro:"home"`
+	Extra    interface{}       `avro:"extra"`
+	Password string            `avro:"-"`
+}
+
+const userSchema = `{
+    "name": "user",
+    "type": "record",
+    "fields": [
+        {"name": "id", "type": "int"},
+        {"name": "name", "type": "string"},
+        {"name": "email", "type": ["null", "string"]},
+        {"name": "scores", "type": {"type": "array", "items": "double"}},
+        {"name": "labels", "type": {"type": "map", "values": "int"}},
+        {"name": "hash", "type": {"type": "fixed", "name": "hash4", "size": 4}},
+        {"name": "kind", "type": {"type": "enum", "name": "kind", "symbols": ["ADMIN", "GUEST"]}},
+        {"name": "home", "type": ["null", {"type": "record", "name": "point", "fields": [
+            {"name": "x", "type": "long"},
+            {"name": "y", "type": "long"}
+        ]}]},
+        {"name": "extra", "type": ["null", "long", "string"]},
+        {"name": "active", "type": "boolean", "default": true}
+    ]
+}`
+
+func TestMarshalStruct(t *testing.T) {
+	schema := parseSchema(t, userSchema)
+	email := "dan@example.com"
+	data := []user{
+		{
+			ID: 1, Name: "dan", Email: &email, Scores: []float64{1.5, 2},
+			Labels: map[string]uint16{"a": 1}, Hash: [4]byte{1, 2, 3, 4}, Kind: "ADMIN",
+			Home: &point{X: 3, Y: -4}, Extra: "x",
+		},
+		{ID: -2, Scores: []float64{}, Labels: map[string]uint16{}, Kind: "GUEST"},
+	}
+	for _, u := range data {
+		var w bytes.Buffer
+		assert.NoError(t, Marshal(&w, schema, u))
+		encoded := w.Bytes()
+
+		// generic decoding sees the same data
+		v, err := schema.Decode(bytes.NewBuffer(encoded))
+		assert.NoError(t, err)
+		assert.Equal(t, u.Name, v.(Record).Values[1])
+		assert.Equal(t, true, v.(Record).Values[9])
+
+		var decoded user
+		decoded.Password = "kept"
+		assert.NoError(t, Unmarshal(bytes.NewBuffer(encoded), schema, &decoded))
+		u.Password = "kept"
+		assert.Equal(t, u, decoded)
+	}
+}
+
+func TestMarshalPointerAndRange(t *testing.T) {
+	schema := RecordSchema{Name: "r", Fields: []RecordField{{Name: "n", Schema: Integer}}}
+	var w bytes.Buffer
+	assert.NoError(t, Marshal(&w, schema, &struct{ N uint8 }{200}))
+	var small struct{ N int8 }
+	err := Unmarshal(&w, schema, &small)
+	assert.EqualError(t, err, "n: ValueError. Expect int8, found 200 of type int32")
+
+	assert.Error(t, Marshal(&w, schema, struct{ N int64 }{1 << 40}))
+	assert.Error(t, Marshal(&w, schema, struct{ N string }{"1"}))
+	err = Unmarshal(&w, schema, &struct{ N []int }{})
+	assert.EqualError(t, err, "n: can't bind []int to int: incompatible types")
+	assert.Error(t, Unmarshal(&w, schema, small))
+}
+
+type treeNode struct {
+	Label    string
+	Children []treeNode
+	Next     *treeNode
+}
+
+func TestMarshalRecursive(t *testing.T) {
+	schema := parseSchema(t, `{
+        "name": "Node",
+        "type": "record",
+        "fields": [
+            {"name": "label", "type": "string"},
+            {"name": "children", "type": {"type": "array", "items": "Node"}},
+            {"name": "next", "type": ["null", "Node"]}
+        ]
+    }`)
+	tree := treeNode{
+		Label:    "root",
+		Children: []treeNode{{Label: "a", Children: []treeNode{}}, {Label: "b", Children: []treeNode{}}},
+		Next:     &treeNode{Label: "c", Children: []treeNode{}},
+	}
+	var w bytes.Buffer
+	assert.NoError(t, Marshal(&w, schema, tree))
+	var decoded treeNode
+	assert.NoError(t, Unmarshal(&w, schema, &decoded))
+	assert.Equal(t, tree, decoded)
+}
+
+func TestMarshalSkipsUnknownFields(t *testing.T) {
+	schema := parseSchema(t, `{"name": "p", "type": "record", "fields": [
+        {"name": "x", "type": "long"},
+        {"name": "label", "type": "string"},
+        {"name": "y", "type": "long"}
+    ]}`)
+	var w bytes.Buffer
+	assert.NoError(t, schema.Encode(&w, Record{Schema: schema, Values: []interface{}{1, "p", 2}}))
+	var p point
+	assert.NoError(t, Unmarshal(&w, schema, &p))
+	assert.Equal(t, point{X: 1, Y: 2}, p)
+	err := Marshal(&w, schema, p)
+	assert.EqualError(t, err, "label: no struct field and no default")
+}
+
+func TestMarshalPlanPerSchema(t *testing.T) {
+	type p struct{ X int64 }
+	type w struct{ M map[string]p }
+	longs := parseSchema(t, `{"type": "record", "name": "W", "fields": [{"name": "M", "type": {"type": "map",
+		"values": {"type": "record", "name": "P", "fields": [{"name": "X", "type": "long"}]}}}]}`)
+	ints := parseSchema(t, `{"type": "record", "name": "W", "fields": [{"name": "M", "type": {"type": "map",
+		"values": {"type": "record", "name": "P", "fields": [{"name": "X", "type": "int"}]}}}]}`)
+	v := w{M: map[string]p{"a": {X: 1 << 40}}}
+	var buf bytes.Buffer
+	assert.NoError(t, Marshal(&buf, longs, v))
+	assert.Error(t, Marshal(&buf, ints, v))
+
+	// schemas differing only in defaults have their own plans too
+	type q struct{ A int64 }
+	one := parseSchema(t, `{"type": "record", "name": "Q", "fields": [{"name": "A", "type": "long"}, {"name": "B", "type": "long", "default": 1}]}`)
+	two := parseSchema(t, `{"type": "record", "name": "Q", "fields": [{"name": "A", "type": "long"}, {"name": "B", "type": "long", "default": 2}]}`)
+	for _, c := range []struct {
+		schema Schema
+		b      int
+	}{{one, 1}, {two, 2}} {
+		buf.Reset()
+		assert.NoError(t, Marshal(&buf, c.schema, q{A: 0}))
+		assert.Equal(t, []byte{0, byte(c.b * 2)}, buf.Bytes())
+	}
+
+	// and schemas differing in block size
+	for _, size := range []int{0, 1} {
+		buf.Reset()
+		assert.NoError(t, Marshal(&buf, ArraySchema{ItemSchema: Long, BlockSize: size}, []int64{1, 2}))
+		expected := []byte{4, 2, 4, 0}
+		if size == 1 {
+			expected = []byte{2, 2, 2, 4, 0}
+		}
+		assert.Equal(t, expected, buf.Bytes(), size)
+	}
+}
+
+func TestBind(t *testing.T) {
+	type q struct{ A int64 }
+	schema := parseSchema(t, `{"type": "record", "name": "Q", "fields": [{"name": "A", "type": "long"}]}`)
+	b, err := Bind(reflect.TypeOf(q{}), schema)
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	assert.NoError(t, b.Marshal(&buf, q{A: 3}))
+	assert.Equal(t, []byte{6}, buf.Bytes())
+	var res q
+	assert.NoError(t, b.Unmarshal(&buf, &res))
+	assert.Equal(t, q{A: 3}, res)
+
+	assert.IsType(t, ValueError{}, b.Marshal(&buf, &q{}))
+	assert.IsType(t, ValueError{}, b.Marshal(&buf, nil))
+	assert.IsType(t, ValueError{}, b.Unmarshal(&buf, res))
+	assert.IsType(t, ValueError{}, b.Unmarshal(&buf, new(int64)))
+
+	_, err = Bind(reflect.TypeOf(""), schema)
+	assert.IsType(t, BindError{}, err)
+}
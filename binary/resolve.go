@@ -0,0 +1,269 @@
+package binary
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/galtsev/avro"
+	"io"
+)
+
+// ResolutionError reports writer and reader schemas which do not match.
+type ResolutionError struct {
+	Writer string
+	Reader string
+	Reason string
+}
+
+func (err ResolutionError) Error() string {
+	return fmt.Sprintf("can't read %s as %s: %s", err.Writer, err.Reader, err.Reason)
+}
+
+func resolutionError(writer, reader Schema, reason string) error {
+	return ResolutionError{Writer: writer.SchemaName(), Reader: reader.SchemaName(), Reason: reason}
+}
+
+var errDecodeOnly = errors.New("resolving schema can only decode")
+
+// resolvingSchema decodes data written with writer schema into values of reader schema.
+type resolvingSchema struct {
+	writer Schema
+	reader Schema
+	decode func(r Reader) (interface{}, error)
+}
+
+func (schema resolvingSchema) String() string {
+	return fmt.Sprintf("Resolving<%s as %s>", schema.writer.SchemaName(), schema.reader.SchemaName())
+}
+
+func (schema resolvingSchema) Encode(w io.Writer, v interface{}) error {
+	return errDecodeOnly
+}
+
+func (schema resolvingSchema) Decode(r Reader) (interface{}, error) {
+	return schema.decode(r)
+}
+
+func (schema resolvingSchema) SchemaName() string {
+	return schema.reader.SchemaName()
+}
+
+// Resolve builds a schema decoding data written with writer schema into values shaped by
+// reader schema, following the schema resolution rules of the Avro specification:
+// record fields are matched by name, writer-only fields are skipped, reader-only fields
+// are filled from defaults, numbers are promoted, and enum symbols and union branches are
+// matched by name. The resulting schema can't encode.
+func Resolve(writer, reader Schema) (Schema, error) {
+	res := resolver{seen: make(map[[2]string]*Schema)}
+	return res.resolve(writer, reader)
+}
+
+type resolver struct {
+	// record pairs being resolved, to terminate recursive schemas
+	seen map[[2]string]*Schema
+}
+
+// follow references to the actual schema definition
+func deref(schema Schema) Schema {
+	for {
+		ref, ok := schema.(SchemaRef)
+		if !ok {
+			return schema
+		}
+		schema = *ref.Target
+	}
+}
+
+// promotions allowed by the spec, by writer and reader schema name
+var promotions = map[[2]string]func(interface{}) interface{}{
+	{"int", "long"}:     func(v interface{}) interface{} { return int(v.(int32)) },
+	{"int", "float"}:    func(v interface{}) interface{} { return float32(v.(int32)) },
+	{"int", "double"}:   func(v interface{}) interface{} { return float64(v.(int32)) },
+	{"long", "float"}:   func(v interface{}) interface{} { return float32(v.(int)) },
+	{"long", "double"}:  func(v interface{}) interface{} { return float64(v.(int)) },
+	{"float", "double"}: func(v interface{}) interface{} { return float64(v.(float32)) },
+	{"string", "bytes"}: func(v interface{}) interface{} { return []byte(v.(string)) },
+	{"bytes", "string"}: func(v interface{}) interface{} { return string(v.([]byte)) },
+}
+
+func (res *resolver) resolve(writer, reader Schema) (Schema, error) {
+	writer, reader = deref(writer), deref(reader)
+	if wu, ok := writer.(UnionSchema); ok {
+		return res.resolveWriterUnion(wu, reader)
+	}
+	if ru, ok := reader.(UnionSchema); ok {
+		return res.resolveReaderUnion(writer, ru)
+	}
+	switch w := writer.(type) {
+	case RecordSchema:
+		if r, ok := reader.(RecordSchema); ok && w.Name == r.Name {
+			return res.resolveRecord(w, r)
+		}
+	case EnumSchema:
+		if r, ok := reader.(EnumSchema); ok && w.Name == r.Name {
+			return resolveEnum(w, r), nil
+		}
+	case FixedSchema:
+		if r, ok := reader.(FixedSchema); ok && w.Name == r.Name {
+			if w.Size != r.Size {
+				return nil, resolutionError(writer, reader, fmt.Sprintf("size %d differs from %d", w.Size, r.Size))
+			}
+			return writer, nil
+		}
+	case ArraySchema:
+		if r, ok := reader.(ArraySchema); ok {
+			items, err := res.resolve(w.ItemSchema, r.ItemSchema)
+			if err != nil {
+				return nil, err
+			}
+			return ArraySchema{ItemSchema: items}, nil
+		}
+	case MapSchema:
+		if r, ok := reader.(MapSchema); ok {
+			values, err := res.resolve(w.ValueSchema, r.ValueSchema)
+			if err != nil {
+				return nil, err
+			}
+			return MapSchema{ValueSchema: values}, nil
+		}
+	default:
+		if writer.SchemaName() == reader.SchemaName() {
+			return writer, nil
+		}
+		if promote, ok := promotions[[2]string{writer.SchemaName(), reader.SchemaName()}]; ok {
+			decode := func(r Reader) (interface{}, error) {
+				v, err := writer.Decode(r)
+				if err != nil {
+					return nil, err
+				}
+				return promote(v), nil
+			}
+			return resolvingSchema{writer: writer, reader: reader, decode: decode}, nil
+		}
+	}
+	return nil, resolutionError(writer, reader, "incompatible types")
+}
+
+// Every branch of the writer union is resolved against the reader. Branches which don't
+// match the reader are only an error if they occur in data.
+func (res *resolver) resolveWriterUnion(writer UnionSchema, reader Schema) (Schema, error) {
+	options := make([]Schema, len(writer.Options))
+	errs := make([]error, len(writer.Options))
+	for i, option := range writer.Options {
+		options[i], errs[i] = res.resolve(option, reader)
+	}
+	decode := func(r Reader) (interface{}, error) {
+		ind, err := DecodeVarInt(r)
+		if err != nil {
+			return nil, err
+		}
+		if ind < 0 || ind >= len(options) {
+			return nil, ValueError{Value: ind, ExpectedType: fmt.Sprintf("union index below %d", len(options))}
+		}
+		if errs[ind] != nil {
+			return nil, freshError(errs[ind])
+		}
+		return options[ind].Decode(r)
+	}
+	return resolvingSchema{writer: writer, reader: reader, decode: decode}, nil
+}
+
+// freshError returns a copy of err, as callers may add to the path of PathError
+func freshError(err error) error {
+	if perr, ok := err.(*PathError); ok {
+		return &PathError{Path: append([]string(nil), perr.Path...), Err: perr.Err}
+	}
+	return err
+}
+
+// Writer schema is resolved against the first reader branch that matches it.
+func (res *resolver) resolveReaderUnion(writer Schema, reader UnionSchema) (Schema, error) {
+	// exact match first, so that int is not promoted when the reader union has int as well
+	for _, option := range reader.Options {
+		if deref(option).SchemaName() == writer.SchemaName() {
+			return res.resolve(writer, option)
+		}
+	}
+	for _, option := range reader.Options {
+		if schema, err := res.resolve(writer, option); err == nil {
+			return schema, nil
+		}
+	}
+	return nil, resolutionError(writer, reader, "no matching union branch")
+}
+
+func (res *resolver) resolveRecord(writer, reader RecordSchema) (_ Schema, err error) {
+	key := [2]string{writer.SchemaName(), reader.SchemaName()}
+	if target, ok := res.seen[key]; ok {
+		return SchemaRef{Name: reader.SchemaName(), Target: target}, nil
+	}
+	target := new(Schema)
+	res.seen[key] = target
+	defer func() {
+		if err != nil {
+			delete(res.seen, key)
+		}
+	}()
+
+	// position of each writer field in reader record, -1 if it is skipped
+	positions := make([]int, len(writer.Fields))
+	fields := make([]Schema, len(writer.Fields))
+	found := make([]bool, len(reader.Fields))
+	for i, wf := range writer.Fields {
+		positions[i] = -1
+		fields[i] = wf.Schema
+		for j, rf := range reader.Fields {
+			if rf.Name != wf.Name {
+				continue
+			}
+			schema, err := res.resolve(wf.Schema, rf.Schema)
+			if err != nil {
+				return nil, WithPath(err, wf.Name)
+			}
+			positions[i], fields[i], found[j] = j, schema, true
+			break
+		}
+	}
+	for j, rf := range reader.Fields {
+		if !found[j] && !rf.HasDefault {
+			return nil, resolutionError(writer, reader, fmt.Sprintf("field %s has no default", rf.Name))
+		}
+	}
+	decode := func(r Reader) (interface{}, error) {
+		rec := Record{Schema: reader, Values: make([]interface{}, len(reader.Fields))}
+		for j, rf := range reader.Fields {
+			if !found[j] {
+				// every record gets its own copy, defaults of the schema must not change
+				rec.Values[j] = CopyValue(rf.Default)
+			}
+		}
+		for i, field := range fields {
+			v, err := field.Decode(r)
+			if err != nil {
+				return nil, WithPath(err, writer.Fields[i].Name)
+			}
+			if positions[i] >= 0 {
+				rec.Values[positions[i]] = v
+			}
+		}
+		return rec, nil
+	}
+	*target = resolvingSchema{writer: writer, reader: reader, decode: decode}
+	return *target, nil
+}
+
+func resolveEnum(writer, reader EnumSchema) Schema {
+	decode := func(r Reader) (interface{}, error) {
+		v, err := writer.Decode(r)
+		if err != nil {
+			return nil, err
+		}
+		if reader.SymbolIndex(v.(string)) >= 0 {
+			return v, nil
+		}
+		if reader.Default != "" {
+			return reader.Default, nil
+		}
+		return nil, ValueError{Value: v, ExpectedType: reader.String()}
+	}
+	return resolvingSchema{writer: writer, reader: reader, decode: decode}
+}
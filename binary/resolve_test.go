@@ -0,0 +1,174 @@
+package binary
+
+import (
+	"bytes"
+	. "github.com/galtsev/avro"
+	"github.com/stretchr/testify/assert"
+
+	"testing"
+)
+
+func parseSchema(t *testing.T, j string) Schema {
+	schema, err := NewRepo().Append(j)
+	assert.NoError(t, err)
+	return schema
+}
+
+// encode values with writer schema and decode them resolved to reader schema
+func resolveValues(t *testing.T, writer, reader Schema, values []interface{}) []interface{} {
+	resolved, err := Resolve(writer, reader)
+	assert.NoError(t, err)
+	var w bytes.Buffer
+	for _, v := range values {
+		assert.NoError(t, writer.Encode(&w, v))
+	}
+	var res []interface{}
+	for range values {
+		v, err := resolved.Decode(&w)
+		assert.NoError(t, err)
+		res = append(res, v)
+	}
+	assert.Equal(t, 0, w.Len())
+	return res
+}
+
+func TestResolveRecord(t *testing.T) {
+	writer := parseSchema(t, `{
+        "name": "user",
+        "type": "record",
+        "fields": [
+            {"name": "id", "type": "int"},
+            {"name": "password", "type": "string"},
+            {"name": "name", "type": "string"},
+            {"name": "tags", "type": {"type": "array", "items": "string"}}
+        ]
+    }`)
+	reader := RecordSchema{Name: "user", Fields: []RecordField{
+		{Name: "name", Schema: Bytes},
+		{Name: "id", Schema: Double},
+		{Name: "active", Schema: Boolean, Default: true, HasDefault: true},
+		{Name: "tags", Schema: ArraySchema{ItemSchema: String}},
+	}}
+	values := resolveValues(t, writer, reader, []interface{}{
+		Record{Schema: writer, Values: []interface{}{int32(7), "secret", "dan", []interface{}{"a", "b"}}},
+	})
+	expected := Record{Schema: reader, Values: []interface{}{[]byte("dan"), float64(7), true, []interface{}{"a", "b"}}}
+	assert.Equal(t, expected, values[0])
+
+	extended := RecordSchema{Name: "user", Fields: []RecordField{{Name: "email", Schema: String}}}
+	_, err := Resolve(writer, extended)
+	assert.EqualError(t, err, "can't read user as user: field email has no default")
+
+	renamed := RecordSchema{Name: "account", Fields: reader.Fields}
+	_, err = Resolve(writer, renamed)
+	assert.IsType(t, ResolutionError{}, err)
+}
+
+func TestResolveFieldError(t *testing.T) {
+	writer := RecordSchema{Name: "r", Fields: []RecordField{{Name: "pos", Schema: subrecordSchema}}}
+	reader := RecordSchema{Name: "r", Fields: []RecordField{{Name: "pos", Schema: RecordSchema{
+		Name:   "sub",
+		Fields: []RecordField{{Name: "l", Schema: Integer}},
+	}}}}
+	_, err := Resolve(writer, reader)
+	assert.EqualError(t, err, "pos.l: can't read long as int: incompatible types")
+}
+
+func TestResolveEnum(t *testing.T) {
+	writer := EnumSchema{Name: "Suit", Symbols: []string{"SPADES", "HEARTS", "JOKER"}}
+	reader := EnumSchema{Name: "Suit", Symbols: []string{"HEARTS", "SPADES", "UNKNOWN"}, Default: "UNKNOWN"}
+	values := resolveValues(t, writer, reader, []interface{}{"SPADES", "JOKER", "HEARTS"})
+	assert.Equal(t, []interface{}{"SPADES", "UNKNOWN", "HEARTS"}, values)
+
+	reader.Default = ""
+	resolved, err := Resolve(writer, reader)
+	assert.NoError(t, err)
+	_, err = resolved.Decode(bytes.NewBuffer([]byte{4}))
+	assert.IsType(t, ValueError{}, err)
+}
+
+func TestResolveUnion(t *testing.T) {
+	nullableInt := UnionSchema{Options: []Schema{Null, Integer}}
+	nullableLong := UnionSchema{Options: []Schema{Null, Long}}
+	values := resolveValues(t, nullableInt, nullableLong, []interface{}{nil, int32(3)})
+	assert.Equal(t, []interface{}{nil, 3}, values)
+
+	values = resolveValues(t, Integer, UnionSchema{Options: []Schema{Null, String, Double}}, []interface{}{int32(3)})
+	assert.Equal(t, []interface{}{float64(3)}, values)
+
+	values = resolveValues(t, Integer, UnionSchema{Options: []Schema{Double, Integer}}, []interface{}{int32(3)})
+	assert.Equal(t, []interface{}{int32(3)}, values)
+
+	resolved, err := Resolve(UnionSchema{Options: []Schema{Null, String}}, String)
+	assert.NoError(t, err)
+	v, err := resolved.Decode(bytes.NewBuffer([]byte{2, 2, 'a'}))
+	assert.NoError(t, err)
+	assert.Equal(t, "a", v)
+	_, err = resolved.Decode(bytes.NewBuffer([]byte{0}))
+	assert.IsType(t, ResolutionError{}, err)
+
+	_, err = Resolve(Boolean, nullableLong)
+	assert.IsType(t, ResolutionError{}, err)
+}
+
+func TestResolveUnionBranchErrorPath(t *testing.T) {
+	writer := parseSchema(t, `{"type": "record", "name": "r", "fields": [
+		{"name": "f", "type": ["null", {"type": "record", "name": "s", "fields": [{"name": "l", "type": "string"}]}]}]}`)
+	reader := parseSchema(t, `{"type": "record", "name": "r", "fields": [
+		{"name": "f", "type": ["null", {"type": "record", "name": "s", "fields": [{"name": "l", "type": "int"}]}]}]}`)
+	resolved, err := Resolve(writer, reader)
+	assert.NoError(t, err)
+	// the error of the branch is reported the same way every time
+	for i := 0; i < 3; i++ {
+		_, err = resolved.Decode(bytes.NewBuffer([]byte{2, 2, 'a'}))
+		assert.EqualError(t, err, "f.l: can't read string as int: incompatible types")
+	}
+}
+
+func TestResolveDefaultsCopied(t *testing.T) {
+	writer := parseSchema(t, `{"type": "record", "name": "r", "fields": []}`)
+	reader := parseSchema(t, `{"type": "record", "name": "r", "fields": [
+		{"name": "m", "type": {"type": "map", "values": "int"}, "default": {"a": 1}},
+		{"name": "b", "type": "bytes", "default": "xy"}]}`)
+	values := resolveValues(t, writer, reader, []interface{}{Record{}, Record{}})
+	first := values[0].(Record).Values
+	first[0].(map[string]interface{})["a"] = int32(5)
+	first[1].([]byte)[0] = 'z'
+	assert.Equal(t, []interface{}{map[string]interface{}{"a": int32(1)}, []byte("xy")}, values[1].(Record).Values)
+	assert.Equal(t, map[string]interface{}{"a": int32(1)}, reader.(RecordSchema).Fields[0].Default)
+}
+
+func TestResolveRecursive(t *testing.T) {
+	writer := parseSchema(t, `{
+        "name": "Node",
+        "type": "record",
+        "fields": [
+            {"name": "value", "type": "int"},
+            {"name": "next", "type": ["null", "Node"]}
+        ]
+    }`)
+	reader := parseSchema(t, `{
+        "name": "Node",
+        "type": "record",
+        "fields": [
+            {"name": "next", "type": ["null", "Node"]},
+            {"name": "value", "type": "long"}
+        ]
+    }`)
+	list := Record{Schema: writer, Values: []interface{}{int32(1), Record{Schema: writer, Values: []interface{}{int32(2), nil}}}}
+	values := resolveValues(t, writer, reader, []interface{}{list})
+	expected := Record{Schema: reader, Values: []interface{}{Record{Schema: reader, Values: []interface{}{nil, 2}}, 1}}
+	assert.Equal(t, expected, values[0])
+}
+
+func TestResolveParsedDefaults(t *testing.T) {
+	writer := parseSchema(t, `{"name": "r", "type": "record", "fields": [{"name": "id", "type": "long"}]}`)
+	reader := parseSchema(t, `{"name": "r", "type": "record", "fields": [
+        {"name": "id", "type": "long"},
+        {"name": "score", "type": ["null", "double"], "default": null},
+        {"name": "labels", "type": {"type": "map", "values": "int"}, "default": {"a": 1}}
+    ]}`)
+	values := resolveValues(t, writer, reader, []interface{}{Record{Schema: writer, Values: []interface{}{3}}})
+	expected := Record{Schema: reader, Values: []interface{}{3, nil, map[string]interface{}{"a": int32(1)}}}
+	assert.Equal(t, expected, values[0])
+}
@@ -0,0 +1,163 @@
+package binary
+
+import (
+	"bytes"
+	benc "encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/galtsev/avro"
+	"io"
+	"sync"
+)
+
+// Single-object encoding prefixes binary data with this marker
+// and the little-endian CRC-64-AVRO fingerprint of the writer schema.
+var singleObjectMarker = [2]byte{0xC3, 0x01}
+
+// ErrBadMarker reports data not starting with single-object marker.
+var ErrBadMarker = errors.New("not single-object encoded: bad marker")
+
+// UnknownFingerprintError reports single-object data written with unknown schema.
+type UnknownFingerprintError struct {
+	Fingerprint uint64
+}
+
+func (err UnknownFingerprintError) Error() string {
+	return fmt.Sprintf("unknown schema fingerprint %016x", err.Fingerprint)
+}
+
+// SingleObjectEncoder writes values of schema in single-object encoding.
+type SingleObjectEncoder struct {
+	schema Schema
+	header [10]byte
+}
+
+func NewSingleObjectEncoder(schema Schema) (*SingleObjectEncoder, error) {
+	fp, err := Fingerprint64(schema)
+	if err != nil {
+		return nil, err
+	}
+	res := SingleObjectEncoder{schema: schema}
+	copy(res.header[:], singleObjectMarker[:])
+	benc.LittleEndian.PutUint64(res.header[2:], fp)
+	return &res, nil
+}
+
+func (e *SingleObjectEncoder) Encode(w io.Writer, v interface{}) error {
+	var buf bytes.Buffer
+	buf.Write(e.header[:])
+	if err := e.schema.Encode(&buf, v); err != nil {
+		return err
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+func (e *SingleObjectEncoder) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := e.Encode(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SchemaResolver finds schema by its CRC-64-AVRO fingerprint.
+type SchemaResolver interface {
+	SchemaByFingerprint(fp uint64) (Schema, bool)
+}
+
+// SchemaStore is SchemaResolver holding known writer schemas.
+// Each schema is parsed in its own repo, so versions of the same type don't clash.
+type SchemaStore struct {
+	lock    sync.RWMutex
+	schemas map[uint64]Schema
+}
+
+func NewSchemaStore() *SchemaStore {
+	return &SchemaStore{schemas: make(map[uint64]Schema)}
+}
+
+// Add makes schema known to the store, returning its fingerprint.
+func (s *SchemaStore) Add(schema Schema) (uint64, error) {
+	fp, err := Fingerprint64(schema)
+	if err != nil {
+		return 0, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.schemas[fp] = schema
+	return fp, nil
+}
+
+// Append parses JSON schema and adds it to the store.
+func (s *SchemaStore) Append(j string) (Schema, error) {
+	schema, err := NewRepo().Append(j)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = s.Add(schema); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+func (s *SchemaStore) SchemaByFingerprint(fp uint64) (Schema, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	schema, ok := s.schemas[fp]
+	return schema, ok
+}
+
+// SingleObjectDecoder reads single-object encoded values, finding writer schema with resolver.
+type SingleObjectDecoder struct {
+	resolver SchemaResolver
+	reader   Schema
+	// writer schemas resolved to reader schema, by fingerprint
+	resolved sync.Map
+}
+
+// NewSingleObjectDecoder creates decoder returning values of readerSchema.
+// If readerSchema is nil, values are returned as written.
+func NewSingleObjectDecoder(resolver SchemaResolver, readerSchema Schema) *SingleObjectDecoder {
+	return &SingleObjectDecoder{resolver: resolver, reader: readerSchema}
+}
+
+func (d *SingleObjectDecoder) schema(fp uint64) (Schema, error) {
+	if schema, ok := d.resolved.Load(fp); ok {
+		return schema.(Schema), nil
+	}
+	schema, ok := d.resolver.SchemaByFingerprint(fp)
+	if !ok {
+		return nil, UnknownFingerprintError{Fingerprint: fp}
+	}
+	if d.reader != nil {
+		var err error
+		if schema, err = Resolve(schema, d.reader); err != nil {
+			return nil, err
+		}
+	}
+	d.resolved.Store(fp, schema)
+	return schema, nil
+}
+
+func (d *SingleObjectDecoder) Decode(r Reader) (interface{}, error) {
+	var header [10]byte
+	if _, err := io.ReadFull(r, header[:2]); err != nil {
+		return nil, err
+	}
+	if [2]byte(header[:2]) != singleObjectMarker {
+		return nil, ErrBadMarker
+	}
+	if _, err := io.ReadFull(r, header[2:]); err != nil {
+		return nil, err
+	}
+	schema, err := d.schema(benc.LittleEndian.Uint64(header[2:]))
+	if err != nil {
+		return nil, err
+	}
+	return schema.Decode(r)
+}
+
+func (d *SingleObjectDecoder) Unmarshal(data []byte) (interface{}, error) {
+	return d.Decode(bytes.NewReader(data))
+}
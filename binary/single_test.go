@@ -0,0 +1,77 @@
+package binary
+
+import (
+	"bytes"
+	"fmt"
+	. "github.com/galtsev/avro"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func TestSingleObject(t *testing.T) {
+	store := NewSchemaStore()
+	writer, err := store.Append(`{"type": "record", "name": "r", "fields": [{"name": "a", "type": "int"}]}`)
+	assert.NoError(t, err)
+	encoder, err := NewSingleObjectEncoder(writer)
+	assert.NoError(t, err)
+	data, err := encoder.Marshal(Record{Values: []interface{}{int32(3)}})
+	assert.NoError(t, err)
+	fp, _ := Fingerprint64(writer)
+	assert.Equal(t, []byte{0xC3, 0x01}, data[:2])
+	assert.Equal(t, byte(fp), data[2])
+	assert.Equal(t, []byte{6}, data[10:])
+
+	v, err := NewSingleObjectDecoder(store, nil).Unmarshal(data)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int32(3)}, v.(Record).Values)
+
+	reader := parseSchema(t, `{"type": "record", "name": "r", "fields": [
+		{"name": "a", "type": "long"}, {"name": "b", "type": "string", "default": "x"}]}`)
+	decoder := NewSingleObjectDecoder(store, reader)
+	for i := 0; i < 2; i++ {
+		v, err = decoder.Unmarshal(data)
+		assert.NoError(t, err)
+		assert.Equal(t, []interface{}{3, "x"}, v.(Record).Values)
+	}
+
+	// a stream of messages
+	var buf bytes.Buffer
+	assert.NoError(t, encoder.Encode(&buf, Record{Values: []interface{}{int32(1)}}))
+	assert.NoError(t, encoder.Encode(&buf, Record{Values: []interface{}{int32(2)}}))
+	for _, expected := range []int{1, 2} {
+		v, err = decoder.Decode(&buf)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, v.(Record).Values[0])
+	}
+	_, err = decoder.Decode(&buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestSingleObjectErrors(t *testing.T) {
+	store := NewSchemaStore()
+	decoder := NewSingleObjectDecoder(store, nil)
+	_, err := decoder.Unmarshal([]byte{0xC3, 0x02, 0, 0, 0, 0, 0, 0, 0, 0})
+	assert.Equal(t, ErrBadMarker, err)
+
+	encoder, err := NewSingleObjectEncoder(Long)
+	assert.NoError(t, err)
+	data, err := encoder.Marshal(5)
+	assert.NoError(t, err)
+	_, err = decoder.Unmarshal(data)
+	fp, _ := Fingerprint64(Long)
+	assert.Equal(t, UnknownFingerprintError{Fingerprint: fp}, err)
+	assert.EqualError(t, err, "unknown schema fingerprint "+fmt.Sprintf("%016x", fp))
+
+	_, err = decoder.Unmarshal(data[:5])
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, err = store.Add(Long)
+	assert.NoError(t, err)
+	v, err := decoder.Unmarshal(data)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, v)
+
+	_, err = NewSingleObjectDecoder(store, String).Unmarshal(data)
+	assert.IsType(t, ResolutionError{}, err)
+}
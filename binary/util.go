@@ -5,14 +5,9 @@ import (
 	. "github.com/galtsev/avro"
 
 	"io"
+	"math"
 )
 
-func check(err error) {
-	if err != nil {
-		panic(err)
-	}
-}
-
 func zencode(v int) uint64 {
 	//return uint64((v >> 63) ^ (v << 1))
 	if v >= 0 {
@@ -31,15 +26,113 @@ func zdecode(v uint64) int {
 	}
 }
 
-func EncodeVarInt(w io.Writer, v int) {
+func EncodeVarInt(w io.Writer, v int) error {
 	var buf [10]byte
 	l := benc.PutUvarint(buf[:], uint64(zencode(v)))
 	_, err := w.Write(buf[:l])
-	check(err)
+	return err
 }
 
-func DecodeVarInt(r Reader) int {
+func DecodeVarInt(r Reader) (int, error) {
 	v, err := benc.ReadUvarint(r)
-	check(err)
-	return zdecode(v)
+	return zdecode(v), err
+}
+
+func EncodeBoolean(w io.Writer, v bool) error {
+	var buf [1]byte
+	if v {
+		buf[0] = 1
+	}
+	_, err := w.Write(buf[:])
+	return err
+}
+
+func DecodeBoolean(r Reader) (bool, error) {
+	b, err := r.ReadByte()
+	return b == 1, err
+}
+
+func EncodeFloat(w io.Writer, v float32) error {
+	var buf [4]byte
+	benc.LittleEndian.PutUint32(buf[:], math.Float32bits(v))
+	_, err := w.Write(buf[:])
+	return err
+}
+
+func DecodeFloat(r Reader) (float32, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	return math.Float32frombits(benc.LittleEndian.Uint32(buf[:])), nil
+}
+
+func EncodeDouble(w io.Writer, v float64) error {
+	var buf [8]byte
+	benc.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	_, err := w.Write(buf[:])
+	return err
+}
+
+func DecodeDouble(r Reader) (float64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(benc.LittleEndian.Uint64(buf[:])), nil
+}
+
+func EncodeBytes(w io.Writer, buf []byte) error {
+	if err := EncodeVarInt(w, len(buf)); err != nil {
+		return err
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+func DecodeBytes(r Reader) ([]byte, error) {
+	bufLen, err := DecodeVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if bufLen < 0 {
+		return nil, ValueError{Value: bufLen, ExpectedType: "non-negative length"}
+	}
+	if err := checkLength(r, "bytes", bufLen); err != nil {
+		return nil, err
+	}
+	if err := checkAvailable(r, bufLen); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, bufLen, bufLen)
+	_, err = io.ReadFull(r, buf)
+	return buf, err
+}
+
+func EncodeString(w io.Writer, v string) error {
+	if err := EncodeVarInt(w, len(v)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, v)
+	return err
+}
+
+func DecodeString(r Reader) (string, error) {
+	buf, err := DecodeBytes(r)
+	return string(buf), err
+}
+
+// Arrays and maps are written as a series of blocks, each prefixed with its item count
+// and terminated by a block of zero items. A negative count is followed by the block
+// size in bytes. DecodeBlockCount returns the item count of the next block, 0 at the end.
+func DecodeBlockCount(r Reader) (int, error) {
+	count, err := DecodeVarInt(r)
+	if err != nil {
+		return 0, err
+	}
+	if count < 0 {
+		count = -count
+		_, err = DecodeVarInt(r)
+	}
+	return count, err
 }
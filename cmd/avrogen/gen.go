@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/galtsev/avro"
+	"github.com/galtsev/avro/binary"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// generator accumulates Go declarations for schemas and the named types they use.
+type generator struct {
+	pkg   string
+	decls bytes.Buffer
+	// Go type names already declared, by Avro fullname or union type name
+	declared map[string]string
+	// declared Go names, to detect clashes of types from different namespaces
+	goNames map[string]string
+	// queue of named schemas and unions still to declare
+	pending []avro.Schema
+}
+
+func newGenerator(pkg string) *generator {
+	return &generator{pkg: pkg, declared: make(map[string]string), goNames: make(map[string]string)}
+}
+
+// Go identifier from Avro name: dotted namespace dropped, words joined in CamelCase
+func goName(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	var res strings.Builder
+	upper := true
+	for _, c := range name {
+		if c == '_' || c == '-' {
+			upper = true
+			continue
+		}
+		if upper {
+			c = unicode.ToUpper(c)
+			upper = false
+		}
+		res.WriteRune(c)
+	}
+	return res.String()
+}
+
+func deref(schema avro.Schema) avro.Schema {
+	for {
+		ref, ok := schema.(binary.SchemaRef)
+		if !ok {
+			return schema
+		}
+		schema = *ref.Target
+	}
+}
+
+var primitiveTypes = map[string]string{
+	"null":    "struct{}",
+	"boolean": "bool",
+	"int":     "int32",
+	"long":    "int64",
+	"float":   "float32",
+	"double":  "float64",
+	"bytes":   "[]byte",
+	"string":  "string",
+}
+
+// nullable union of null and one other branch is bound to pointer;
+// returns index of null and value branches, ok if schema is such union
+func nullable(schema binary.UnionSchema) (nullIndex, valueIndex int, ok bool) {
+	if len(schema.Options) != 2 {
+		return 0, 0, false
+	}
+	for i, option := range schema.Options {
+		if _, isNull := deref(option).(binary.NullSchema); isNull {
+			return i, 1 - i, true
+		}
+	}
+	return 0, 0, false
+}
+
+// name of union branch, used for wrapper type and field names
+func branchName(schema avro.Schema) string {
+	switch s := deref(schema).(type) {
+	case binary.ArraySchema:
+		return "Array" + branchName(s.ItemSchema)
+	case binary.MapSchema:
+		return "Map" + branchName(s.ValueSchema)
+	case binary.UnionSchema:
+		return unionName(s)
+	}
+	return goName(deref(schema).SchemaName())
+}
+
+func unionName(schema binary.UnionSchema) string {
+	name := "Union"
+	for _, option := range schema.Options {
+		name += branchName(option)
+	}
+	return name
+}
+
+// declare Go name for Avro type key, failing on clash
+func (g *generator) declare(key, name string, schema avro.Schema) (string, error) {
+	if declared, ok := g.declared[key]; ok {
+		return declared, nil
+	}
+	if other, ok := g.goNames[name]; ok {
+		return "", fmt.Errorf("types %s and %s both map to Go type %s", other, key, name)
+	}
+	g.declared[key] = name
+	g.goNames[name] = key
+	g.pending = append(g.pending, schema)
+	return name, nil
+}
+
+// Go type for schema, queueing named types and unions for declaration
+func (g *generator) goType(schema avro.Schema) (string, error) {
+	switch s := deref(schema).(type) {
+	case binary.RecordSchema, binary.EnumSchema, binary.FixedSchema:
+		return g.declare(s.SchemaName(), goName(s.SchemaName()), s)
+	case binary.ArraySchema:
+		item, err := g.goType(s.ItemSchema)
+		return "[]" + item, err
+	case binary.MapSchema:
+		value, err := g.goType(s.ValueSchema)
+		return "map[string]" + value, err
+	case binary.UnionSchema:
+		if _, valueIndex, ok := nullable(s); ok {
+			value, err := g.goType(s.Options[valueIndex])
+			return "*" + value, err
+		}
+		name := unionName(s)
+		return g.declare(name, name, s)
+	default:
+		if t, ok := primitiveTypes[s.SchemaName()]; ok {
+			return t, nil
+		}
+		return "", fmt.Errorf("unsupported schema %s", s)
+	}
+}
+
+const checkErr = "if err != nil {\n\treturn err\n}\n"
+
+func call(expr string) string {
+	return "if err := " + expr + "; err != nil {\n\treturn err\n}\n"
+}
+
+// statements writing expr of schema to w; depth makes local names unique in nested loops
+func (g *generator) encode(schema avro.Schema, expr string, depth int) (string, error) {
+	i := fmt.Sprint("i", depth)
+	switch s := deref(schema).(type) {
+	case binary.RecordSchema, binary.EnumSchema, binary.FixedSchema:
+		return call(expr + ".Encode(w)"), nil
+	case binary.ArraySchema:
+		item, err := g.encode(s.ItemSchema, expr+"["+i+"]", depth+1)
+		if err != nil {
+			return "", err
+		}
+		return "if len(" + expr + ") > 0 {\n" +
+			call("binary.EncodeVarInt(w, len("+expr+"))") +
+			"for " + i + " := range " + expr + " {\n" + item + "}\n}\n" +
+			call("binary.EncodeVarInt(w, 0)"), nil
+	case binary.MapSchema:
+		k := fmt.Sprint("k", depth)
+		item := fmt.Sprint("item", depth)
+		value, err := g.encode(s.ValueSchema, item, depth+1)
+		if err != nil {
+			return "", err
+		}
+		return "if len(" + expr + ") > 0 {\n" +
+			call("binary.EncodeVarInt(w, len("+expr+"))") +
+			"for " + k + ", " + item + " := range " + expr + " {\n" +
+			call("binary.EncodeString(w, "+k+")") + value + "}\n}\n" +
+			call("binary.EncodeVarInt(w, 0)"), nil
+	case binary.UnionSchema:
+		nullIndex, valueIndex, ok := nullable(s)
+		if !ok {
+			return call(expr + ".Encode(w)"), nil
+		}
+		value, err := g.encode(s.Options[valueIndex], "(*"+expr+")", depth)
+		if err != nil {
+			return "", err
+		}
+		return "if " + expr + " == nil {\n" +
+			call(fmt.Sprintf("binary.EncodeVarInt(w, %d)", nullIndex)) +
+			"} else {\n" +
+			call(fmt.Sprintf("binary.EncodeVarInt(w, %d)", valueIndex)) + value + "}\n", nil
+	}
+	switch schema.SchemaName() {
+	case "null":
+		return "", nil
+	case "boolean":
+		return call("binary.EncodeBoolean(w, " + expr + ")"), nil
+	case "int", "long":
+		return call("binary.EncodeVarInt(w, int(" + expr + "))"), nil
+	case "float":
+		return call("binary.EncodeFloat(w, " + expr + ")"), nil
+	case "double":
+		return call("binary.EncodeDouble(w, " + expr + ")"), nil
+	case "bytes":
+		return call("binary.EncodeBytes(w, " + expr + ")"), nil
+	case "string":
+		return call("binary.EncodeString(w, " + expr + ")"), nil
+	}
+	return "", fmt.Errorf("unsupported schema %s", schema)
+}
+
+// statements reading value of schema from r into addressable target
+func (g *generator) decode(schema avro.Schema, target string, depth int) (string, error) {
+	n := fmt.Sprint("n", depth)
+	i := fmt.Sprint("i", depth)
+	switch s := deref(schema).(type) {
+	case binary.RecordSchema, binary.EnumSchema, binary.FixedSchema:
+		return call(target + ".Decode(r)"), nil
+	case binary.ArraySchema:
+		itemType, err := g.goType(s.ItemSchema)
+		if err != nil {
+			return "", err
+		}
+		item := fmt.Sprint("item", depth)
+		value, err := g.decode(s.ItemSchema, item, depth+1)
+		if err != nil {
+			return "", err
+		}
+		return target + " = make([]" + itemType + ", 0)\n" +
+			"for {\n" +
+			n + ", err := binary.DecodeBlockCount(r)\n" + checkErr +
+			"if " + n + " == 0 {\nbreak\n}\n" +
+			"for " + i + " := 0; " + i + " < " + n + "; " + i + "++ {\n" +
+			"var " + item + " " + itemType + "\n" + value +
+			target + " = append(" + target + ", " + item + ")\n}\n}\n", nil
+	case binary.MapSchema:
+		valueType, err := g.goType(s.ValueSchema)
+		if err != nil {
+			return "", err
+		}
+		k := fmt.Sprint("k", depth)
+		item := fmt.Sprint("item", depth)
+		value, err := g.decode(s.ValueSchema, item, depth+1)
+		if err != nil {
+			return "", err
+		}
+		return target + " = make(map[string]" + valueType + ")\n" +
+			"for {\n" +
+			n + ", err := binary.DecodeBlockCount(r)\n" + checkErr +
+			"if " + n + " == 0 {\nbreak\n}\n" +
+			"for " + i + " := 0; " + i + " < " + n + "; " + i + "++ {\n" +
+			k + ", err := binary.DecodeString(r)\n" + checkErr +
+			"var " + item + " " + valueType + "\n" + value +
+			target + "[" + k + "] = " + item + "\n}\n}\n", nil
+	case binary.UnionSchema:
+		nullIndex, valueIndex, ok := nullable(s)
+		if !ok {
+			return call(target + ".Decode(r)"), nil
+		}
+		valueType, err := g.goType(s.Options[valueIndex])
+		if err != nil {
+			return "", err
+		}
+		value, err := g.decode(s.Options[valueIndex], "(*"+target+")", depth)
+		if err != nil {
+			return "", err
+		}
+		return "{\n" +
+			n + ", err := binary.DecodeVarInt(r)\n" + checkErr +
+			"switch " + n + " {\n" +
+			fmt.Sprintf("case %d:\n", nullIndex) + target + " = nil\n" +
+			fmt.Sprintf("case %d:\n", valueIndex) + target + " = new(" + valueType + ")\n" + value +
+			"default:\nreturn avro.ValueError{Value: " + n + ", ExpectedType: \"union index\"}\n}\n}\n", nil
+	}
+	read := func(fn, conv string) string {
+		value := "x"
+		if conv != "" {
+			value = conv + "(x)"
+		}
+		return "{\nx, err := binary." + fn + "(r)\n" + checkErr + target + " = " + value + "\n}\n"
+	}
+	switch schema.SchemaName() {
+	case "null":
+		return "", nil
+	case "boolean":
+		return read("DecodeBoolean", ""), nil
+	case "int":
+		return read("DecodeVarInt", "int32"), nil
+	case "long":
+		return read("DecodeVarInt", "int64"), nil
+	case "float":
+		return read("DecodeFloat", ""), nil
+	case "double":
+		return read("DecodeDouble", ""), nil
+	case "bytes":
+		return read("DecodeBytes", ""), nil
+	case "string":
+		return read("DecodeString", ""), nil
+	}
+	return "", fmt.Errorf("unsupported schema %s", schema)
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.decls, format, args...)
+}
+
+func (g *generator) genRecord(name string, schema binary.RecordSchema) error {
+	var fields, encode, decode strings.Builder
+	for _, f := range schema.Fields {
+		typ, err := g.goType(f.Schema)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", schema.SchemaName(), f.Name, err)
+		}
+		fmt.Fprintf(&fields, "%s %s `avro:%q`\n", goName(f.Name), typ, f.Name)
+		enc, err := g.encode(f.Schema, "v."+goName(f.Name), 0)
+		if err != nil {
+			return err
+		}
+		encode.WriteString(enc)
+		dec, err := g.decode(f.Schema, "v."+goName(f.Name), 0)
+		if err != nil {
+			return err
+		}
+		decode.WriteString(dec)
+	}
+	g.printf("// %s is generated from record %s.\ntype %s struct {\n%s}\n\n", name, schema.SchemaName(), name, fields.String())
+	g.printf("func (v *%s) Encode(w io.Writer) error {\n%sreturn nil\n}\n\n", name, encode.String())
+	g.printf("func (v *%s) Decode(r avro.Reader) error {\n%sreturn nil\n}\n\n", name, decode.String())
+	return nil
+}
+
+func (g *generator) genEnum(name string, schema binary.EnumSchema) {
+	g.printf("// %s is generated from enum %s.\ntype %s int32\n\nconst (\n", name, schema.SchemaName(), name)
+	for i, symbol := range schema.Symbols {
+		if i == 0 {
+			g.printf("%s%s %s = iota\n", name, goName(symbol), name)
+		} else {
+			g.printf("%s%s\n", name, goName(symbol))
+		}
+	}
+	g.printf(")\n\n")
+	symbols := fmt.Sprintf("%#v", schema.Symbols)
+	g.printf("var symbols%s = %s\n\n", name, symbols)
+	g.printf("func (v %s) String() string {\nif v < 0 || int(v) >= len(symbols%s) {\nreturn \"\"\n}\nreturn symbols%s[v]\n}\n\n", name, name, name)
+	g.printf("func (v %s) Encode(w io.Writer) error {\n"+
+		"if v < 0 || int(v) >= len(symbols%s) {\nreturn avro.ValueError{Value: int32(v), ExpectedType: %q}\n}\n"+
+		"return binary.EncodeVarInt(w, int(v))\n}\n\n", name, name, schema.SchemaName())
+	g.printf("func (v *%s) Decode(r avro.Reader) error {\n"+
+		"n, err := binary.DecodeVarInt(r)\n"+checkErr+
+		"if n < 0 || n >= len(symbols%s) {\nreturn avro.ValueError{Value: n, ExpectedType: %q}\n}\n"+
+		"*v = %s(n)\nreturn nil\n}\n\n", name, name, schema.SchemaName(), name)
+}
+
+func (g *generator) genFixed(name string, schema binary.FixedSchema) {
+	g.printf("// %s is generated from fixed %s.\ntype %s [%d]byte\n\n", name, schema.SchemaName(), name, schema.Size)
+	g.printf("func (v *%s) Encode(w io.Writer) error {\n_, err := w.Write(v[:])\nreturn err\n}\n\n", name)
+	g.printf("func (v *%s) Decode(r avro.Reader) error {\n_, err := io.ReadFull(r, v[:])\nreturn err\n}\n\n", name)
+}
+
+func (g *generator) genUnion(name string, schema binary.UnionSchema) error {
+	var fields, encode, decode strings.Builder
+	for i, option := range schema.Options {
+		fmt.Fprintf(&encode, "case %d:\n", i)
+		encode.WriteString(call(fmt.Sprintf("binary.EncodeVarInt(w, %d)", i)))
+		fmt.Fprintf(&decode, "case %d:\n", i)
+		if _, isNull := deref(option).(binary.NullSchema); isNull {
+			continue
+		}
+		field := branchName(option)
+		typ, err := g.goType(option)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&fields, "%s %s\n", field, typ)
+		enc, err := g.encode(option, "v."+field, 0)
+		if err != nil {
+			return err
+		}
+		encode.WriteString(enc)
+		dec, err := g.decode(option, "v."+field, 0)
+		if err != nil {
+			return err
+		}
+		decode.WriteString(dec)
+	}
+	g.printf("// %s holds one branch of union, selected by Index.\ntype %s struct {\nIndex int\n%s}\n\n", name, name, fields.String())
+	g.printf("func (v *%s) Encode(w io.Writer) error {\nswitch v.Index {\n%s"+
+		"default:\nreturn avro.ValueError{Value: v.Index, ExpectedType: %q}\n}\nreturn nil\n}\n\n", name, encode.String(), name+" index")
+	g.printf("func (v *%s) Decode(r avro.Reader) error {\nvar err error\nif v.Index, err = binary.DecodeVarInt(r); err != nil {\nreturn err\n}\n"+
+		"switch v.Index {\n%s"+
+		"default:\nreturn avro.ValueError{Value: v.Index, ExpectedType: %q}\n}\nreturn nil\n}\n\n", name, decode.String(), name+" index")
+	return nil
+}
+
+// Add queues Go declarations for all named types schema uses.
+func (g *generator) Add(schema avro.Schema) error {
+	if _, err := g.goType(schema); err != nil {
+		return err
+	}
+	for len(g.pending) > 0 {
+		s := g.pending[0]
+		g.pending = g.pending[1:]
+		name := g.declared[s.SchemaName()]
+		var err error
+		switch s := s.(type) {
+		case binary.RecordSchema:
+			err = g.genRecord(name, s)
+		case binary.EnumSchema:
+			g.genEnum(name, s)
+		case binary.FixedSchema:
+			g.genFixed(name, s)
+		case binary.UnionSchema:
+			err = g.genUnion(unionName(s), s)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Source returns formatted Go source of all declarations.
+func (g *generator) Source() ([]byte, error) {
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by avrogen. DO NOT EDIT.\n\npackage %s\n\n", g.pkg)
+	imports := []string{"github.com/galtsev/avro", "github.com/galtsev/avro/binary", "io"}
+	sort.Strings(imports)
+	src.WriteString("import (\n")
+	for _, imp := range imports {
+		fmt.Fprintf(&src, "%q\n", imp)
+	}
+	src.WriteString(")\n\n")
+	// keep imports used whatever the schemas are
+	src.WriteString("var _ = binary.EncodeVarInt\n\n")
+	src.Write(g.decls.Bytes())
+	return format.Source(src.Bytes())
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"github.com/galtsev/avro/binary"
+	"github.com/stretchr/testify/assert"
+
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func generate(t *testing.T, schemas ...string) string {
+	repo := binary.NewRepo()
+	gen := newGenerator("example")
+	for _, j := range schemas {
+		schema, err := repo.Append(j)
+		assert.NoError(t, err)
+		assert.NoError(t, gen.Add(schema))
+	}
+	src, err := gen.Source()
+	assert.NoError(t, err)
+	return string(src)
+}
+
+var (
+	kindSchema    = `{"type": "enum", "name": "com.example.kind", "symbols": ["ADMIN", "GUEST"]}`
+	accountSchema = `{
+        "name": "user_account",
+        "namespace": "com.example",
+        "type": "record",
+        "fields": [
+            {"name": "id", "type": "long"},
+            {"name": "email", "type": ["null", "string"]},
+            {"name": "kind", "type": "kind"},
+            {"name": "hash", "type": {"type": "fixed", "name": "md5", "size": 16}},
+            {"name": "extra", "type": ["long", "string"]},
+            {"name": "friends", "type": {"type": "array", "items": "user_account"}}
+        ]
+    }`
+)
+
+func TestGenerate(t *testing.T) {
+	src := generate(t, kindSchema, accountSchema)
+	for _, decl := range []string{
+		"type Kind int32",
+		"KindADMIN Kind = iota",
+		"type UserAccount struct",
+		"type Md5 [16]byte",
+		"type UnionLongString struct",
+		"func (v *UserAccount) Encode(w io.Writer) error",
+		"func (v *UserAccount) Decode(r avro.Reader) error",
+		"binary.EncodeVarInt(w, int(v.Id))",
+	} {
+		assert.True(t, strings.Contains(src, decl), decl)
+	}
+	assert.Regexp(t, "Email +\\*string +`avro:\"email\"`", src)
+	assert.Regexp(t, "Friends +\\[\\]UserAccount +`avro:\"friends\"`", src)
+}
+
+func TestGenerateNameClash(t *testing.T) {
+	repo := binary.NewRepo()
+	gen := newGenerator("example")
+	schema, err := repo.Append(`{"name": "pair", "type": "record", "fields": [
+        {"name": "a", "type": {"type": "fixed", "name": "one.id", "size": 1}},
+        {"name": "b", "type": {"type": "fixed", "name": "two.id", "size": 2}}
+    ]}`)
+	assert.NoError(t, err)
+	assert.EqualError(t, gen.Add(schema), "pair.b: types one.id and two.id both map to Go type Id")
+}
+
+// program encoding a value with generated code, decoding and re-encoding it with binary schema,
+// and decoding the result back with generated code
+const roundTripMain = `package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/galtsev/avro"
+	"github.com/galtsev/avro/binary"
+	"os"
+	"reflect"
+)
+
+func main() {
+	repo := binary.NewRepo()
+	var schema avro.Schema
+	for _, j := range []string{%q, %q} {
+		var err error
+		if schema, err = repo.Append(j); err != nil {
+			panic(err)
+		}
+	}
+	email := "dan@example.com"
+	value := UserAccount{
+		Id:    -300,
+		Email: &email,
+		Kind:  KindGUEST,
+		Hash:  Md5{1, 2, 3, 255},
+		Extra: UnionLongString{Index: 1, String: "x"},
+		Friends: []UserAccount{
+			{Id: 5, Kind: KindADMIN, Extra: UnionLongString{Index: 0, Long: 7}, Friends: []UserAccount{}},
+		},
+	}
+	var generated bytes.Buffer
+	if err := value.Encode(&generated); err != nil {
+		panic(err)
+	}
+	decoded, err := schema.Decode(bytes.NewReader(generated.Bytes()))
+	if err != nil {
+		panic(err)
+	}
+	var reencoded bytes.Buffer
+	if err := schema.Encode(&reencoded, decoded); err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(generated.Bytes(), reencoded.Bytes()) {
+		fmt.Printf("binary encoding %%x differs from generated %%x\n", reencoded.Bytes(), generated.Bytes())
+		os.Exit(1)
+	}
+	var back UserAccount
+	if err := back.Decode(bytes.NewReader(reencoded.Bytes())); err != nil {
+		panic(err)
+	}
+	if !reflect.DeepEqual(value, back) {
+		fmt.Printf("decoded %%+v, expected %%+v\n", back, value)
+		os.Exit(1)
+	}
+}
+`
+
+func TestGenerateRoundTrip(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds generated code")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool is not available")
+	}
+	root, err := exec.Command(goTool, "list", "-m", "-f", "{{.Dir}}").Output()
+	if err != nil {
+		t.Skip("avro module root is not available")
+	}
+	dir := t.TempDir()
+	gen := newGenerator("main")
+	repo := binary.NewRepo()
+	for _, j := range []string{kindSchema, accountSchema} {
+		schema, err := repo.Append(j)
+		assert.NoError(t, err)
+		assert.NoError(t, gen.Add(schema))
+	}
+	src, err := gen.Source()
+	assert.NoError(t, err)
+	mod := fmt.Sprintf("module roundtrip\n\ngo 1.18\n\nrequire github.com/galtsev/avro v0.0.0\n\nreplace github.com/galtsev/avro => %s\n",
+		strings.TrimSpace(string(root)))
+	files := map[string]string{
+		"go.mod":   mod,
+		"types.go": string(src),
+		"main.go":  fmt.Sprintf(roundTripMain, kindSchema, accountSchema),
+	}
+	for name, content := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	if sum, err := os.ReadFile(filepath.Join(strings.TrimSpace(string(root)), "go.sum")); err == nil {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "go.sum"), sum, 0o644))
+	}
+	cmd := exec.Command(goTool, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod")
+	out, err := cmd.CombinedOutput()
+	assert.NoError(t, err, string(out))
+}
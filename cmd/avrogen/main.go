@@ -0,0 +1,60 @@
+/*
+Avrogen generates Go types with Encode and Decode methods from Avro schema files.
+
+Usage:
+
+	avrogen [-package name] [-o output.go] schema.avsc...
+
+Schemas are parsed in the order given, so later files may refer to types declared in
+earlier ones. Records become structs, enums int32 constants, fixed byte arrays,
+["null", T] unions pointers to T and other unions wrapper structs holding the branch index.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/galtsev/avro/binary"
+	"os"
+)
+
+func main() {
+	pkg := flag.String("package", "schema", "package name of generated file")
+	output := flag.String("o", "", "output file, stdout if empty")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: avrogen [-package name] [-o output.go] schema.avsc...")
+		os.Exit(2)
+	}
+	if err := run(*pkg, *output, flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "avrogen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(pkg, output string, files []string) error {
+	repo := binary.NewRepo()
+	gen := newGenerator(pkg)
+	for _, file := range files {
+		j, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		schema, err := repo.Append(string(j))
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		if err := gen.Add(schema); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	src, err := gen.Source()
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(output, src, 0644)
+}
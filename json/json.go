@@ -0,0 +1,370 @@
+/*
+Package json implements the JSON encoding of Avro data for schemas parsed by the binary package.
+
+Values are the same as in binary encoding: records are avro.Record, enums strings,
+bytes and fixed []byte, int is int32 and long is int. In JSON, bytes and fixed are strings
+with characters 0-255 standing for byte values, and non-null union values are wrapped
+in an object with single key naming the branch type, like {"string": "a"}.
+*/
+package json
+
+import (
+	"bytes"
+	ejson "encoding/json"
+	"fmt"
+	"github.com/galtsev/avro"
+	"github.com/galtsev/avro/binary"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Marshal returns JSON encoding of v.
+func Marshal(schema avro.Schema, v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encode(&buf, schema, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode writes JSON encoding of v to w.
+func Encode(w io.Writer, schema avro.Schema, v interface{}) error {
+	data, err := Marshal(schema, v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Unmarshal parses JSON encoded value of schema.
+func Unmarshal(data []byte, schema avro.Schema) (interface{}, error) {
+	return Decode(bytes.NewReader(data), schema)
+}
+
+// Decode reads next JSON value of schema from r. As r may be buffered,
+// use Decoder to read a stream of values.
+func Decode(r io.Reader, schema avro.Schema) (interface{}, error) {
+	return NewDecoder(r).Decode(schema)
+}
+
+// Decoder reads a stream of JSON values, like newline delimited ones.
+type Decoder struct {
+	decoder *ejson.Decoder
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	decoder := ejson.NewDecoder(r)
+	// keep precision of longs
+	decoder.UseNumber()
+	return &Decoder{decoder: decoder}
+}
+
+func (d *Decoder) Decode(schema avro.Schema) (interface{}, error) {
+	var v interface{}
+	if err := d.decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return fromJSON(schema, v)
+}
+
+// TypeName returns name of union branch schema in JSON encoding.
+func TypeName(schema avro.Schema) string {
+	switch s := schema.(type) {
+	case binary.ArraySchema:
+		return "array"
+	case binary.MapSchema:
+		return "map"
+	case binary.SchemaRef:
+		return TypeName(*s.Target)
+	}
+	return schema.SchemaName()
+}
+
+func encodeString(buf *bytes.Buffer, s string) {
+	data, _ := ejson.Marshal(s)
+	buf.Write(data)
+}
+
+// bytes are encoded as string of characters 0-255
+func bytesString(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+func encode(buf *bytes.Buffer, schema avro.Schema, v interface{}) error {
+	invalid := avro.ValueError{Value: v, ExpectedType: schema.SchemaName()}
+	switch s := schema.(type) {
+	case binary.NullSchema:
+		buf.WriteString("null")
+	case binary.BooleanSchema:
+		b, ok := v.(bool)
+		if !ok {
+			return invalid
+		}
+		buf.WriteString(strconv.FormatBool(b))
+	case binary.IntSchema:
+		i, ok := v.(int32)
+		if !ok {
+			return invalid
+		}
+		buf.WriteString(strconv.Itoa(int(i)))
+	case binary.LongSchema:
+		i, ok := v.(int)
+		if !ok {
+			return invalid
+		}
+		buf.WriteString(strconv.Itoa(i))
+	case binary.FloatSchema:
+		f, ok := v.(float32)
+		if !ok || math.IsInf(float64(f), 0) || math.IsNaN(float64(f)) {
+			return invalid
+		}
+		buf.WriteString(strconv.FormatFloat(float64(f), 'g', -1, 32))
+	case binary.DoubleSchema:
+		f, ok := v.(float64)
+		if !ok || math.IsInf(f, 0) || math.IsNaN(f) {
+			return invalid
+		}
+		buf.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+	case binary.StringSchema:
+		str, ok := v.(string)
+		if !ok {
+			return invalid
+		}
+		encodeString(buf, str)
+	case binary.BytesSchema:
+		data, ok := v.([]byte)
+		if !ok {
+			return invalid
+		}
+		encodeString(buf, bytesString(data))
+	case binary.FixedSchema:
+		data, ok := v.([]byte)
+		if !ok || len(data) != s.Size {
+			return invalid
+		}
+		encodeString(buf, bytesString(data))
+	case binary.EnumSchema:
+		str, ok := v.(string)
+		if !ok || s.SymbolIndex(str) < 0 {
+			return invalid
+		}
+		encodeString(buf, str)
+	case binary.ArraySchema:
+		items, ok := v.([]interface{})
+		if !ok {
+			return invalid
+		}
+		buf.WriteByte('[')
+		for i, item := range items {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encode(buf, s.ItemSchema, item); err != nil {
+				return avro.WithPath(err, binary.IndexPath(i))
+			}
+		}
+		buf.WriteByte(']')
+	case binary.MapSchema:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return invalid
+		}
+		// sorted keys make output stable
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			encodeString(buf, key)
+			buf.WriteByte(':')
+			if err := encode(buf, s.ValueSchema, m[key]); err != nil {
+				return avro.WithPath(err, binary.KeyPath(key))
+			}
+		}
+		buf.WriteByte('}')
+	case binary.RecordSchema:
+		rec, ok := v.(avro.Record)
+		if !ok || len(rec.Values) > len(s.Fields) {
+			return invalid
+		}
+		buf.WriteByte('{')
+		for i, f := range s.Fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			encodeString(buf, f.Name)
+			buf.WriteByte(':')
+			// omitted trailing fields take their defaults, as in binary encoding
+			var value interface{}
+			if i < len(rec.Values) {
+				value = rec.Values[i]
+			} else if f.HasDefault {
+				value = f.Default
+			} else {
+				return avro.WithPath(avro.ValueError{Value: nil, ExpectedType: f.Schema.SchemaName()}, f.Name)
+			}
+			if err := encode(buf, f.Schema, value); err != nil {
+				return avro.WithPath(err, f.Name)
+			}
+		}
+		buf.WriteByte('}')
+	case binary.UnionSchema:
+		index, err := s.OptionIndex(v)
+		if err != nil {
+			return err
+		}
+		option := s.Options[index]
+		if _, ok := option.(binary.NullSchema); ok {
+			buf.WriteString("null")
+			return nil
+		}
+		buf.WriteByte('{')
+		encodeString(buf, TypeName(option))
+		buf.WriteByte(':')
+		if err = encode(buf, option, v); err != nil {
+			return err
+		}
+		buf.WriteByte('}')
+	case binary.SchemaRef:
+		return encode(buf, *s.Target, v)
+	default:
+		return fmt.Errorf("json: unsupported schema %s", schema)
+	}
+	return nil
+}
+
+// convert value decoded by encoding/json to value of schema
+func fromJSON(schema avro.Schema, v interface{}) (interface{}, error) {
+	invalid := avro.ValueError{Value: v, ExpectedType: schema.SchemaName()}
+	switch s := schema.(type) {
+	case binary.NullSchema:
+		if v != nil {
+			return nil, invalid
+		}
+		return nil, nil
+	case binary.BooleanSchema:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case binary.IntSchema:
+		if n, ok := v.(ejson.Number); ok {
+			if i, err := strconv.ParseInt(string(n), 10, 32); err == nil {
+				return int32(i), nil
+			}
+		}
+	case binary.LongSchema:
+		if n, ok := v.(ejson.Number); ok {
+			if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+				return int(i), nil
+			}
+		}
+	case binary.FloatSchema:
+		if n, ok := v.(ejson.Number); ok {
+			if f, err := strconv.ParseFloat(string(n), 32); err == nil {
+				return float32(f), nil
+			}
+		}
+	case binary.DoubleSchema:
+		if n, ok := v.(ejson.Number); ok {
+			if f, err := n.Float64(); err == nil {
+				return f, nil
+			}
+		}
+	case binary.StringSchema:
+		if str, ok := v.(string); ok {
+			return str, nil
+		}
+	case binary.BytesSchema:
+		if str, ok := v.(string); ok {
+			if data, ok := binary.ParseBytes(str); ok {
+				return data, nil
+			}
+		}
+	case binary.FixedSchema:
+		if str, ok := v.(string); ok {
+			if data, ok := binary.ParseBytes(str); ok && len(data) == s.Size {
+				return data, nil
+			}
+		}
+	case binary.EnumSchema:
+		if str, ok := v.(string); ok && s.SymbolIndex(str) >= 0 {
+			return str, nil
+		}
+	case binary.ArraySchema:
+		if items, ok := v.([]interface{}); ok {
+			res := make([]interface{}, len(items))
+			for i, item := range items {
+				var err error
+				if res[i], err = fromJSON(s.ItemSchema, item); err != nil {
+					return nil, avro.WithPath(err, binary.IndexPath(i))
+				}
+			}
+			return res, nil
+		}
+	case binary.MapSchema:
+		if m, ok := v.(map[string]interface{}); ok {
+			res := make(map[string]interface{}, len(m))
+			for key, value := range m {
+				var err error
+				if res[key], err = fromJSON(s.ValueSchema, value); err != nil {
+					return nil, avro.WithPath(err, binary.KeyPath(key))
+				}
+			}
+			return res, nil
+		}
+	case binary.RecordSchema:
+		if m, ok := v.(map[string]interface{}); ok {
+			rec := avro.Record{Schema: s, Values: make([]interface{}, len(s.Fields))}
+			for i, f := range s.Fields {
+				value, ok := m[f.Name]
+				if !ok {
+					if !f.HasDefault {
+						return nil, avro.WithPath(avro.ValueError{Value: nil, ExpectedType: f.Schema.SchemaName()}, f.Name)
+					}
+					rec.Values[i] = avro.CopyValue(f.Default)
+					continue
+				}
+				var err error
+				if rec.Values[i], err = fromJSON(f.Schema, value); err != nil {
+					return nil, avro.WithPath(err, f.Name)
+				}
+			}
+			return rec, nil
+		}
+	case binary.UnionSchema:
+		if v == nil {
+			for _, option := range s.Options {
+				if _, ok := option.(binary.NullSchema); ok {
+					return nil, nil
+				}
+			}
+			break
+		}
+		if m, ok := v.(map[string]interface{}); ok && len(m) == 1 {
+			for name, value := range m {
+				for _, option := range s.Options {
+					if TypeName(option) == name {
+						return fromJSON(option, value)
+					}
+				}
+			}
+		}
+	case binary.SchemaRef:
+		return fromJSON(*s.Target, v)
+	default:
+		return nil, fmt.Errorf("json: unsupported schema %s", schema)
+	}
+	return nil, invalid
+}
@@ -0,0 +1,125 @@
+package json
+
+import (
+	"bytes"
+	"github.com/galtsev/avro"
+	"github.com/galtsev/avro/binary"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func parseSchema(t *testing.T, j string) avro.Schema {
+	schema, err := binary.NewRepo().Append(j)
+	assert.NoError(t, err)
+	return schema
+}
+
+func TestPrimitives(t *testing.T) {
+	cases := []struct {
+		schema avro.Schema
+		value  interface{}
+		json   string
+	}{
+		{binary.Null, nil, `null`},
+		{binary.Boolean, true, `true`},
+		{binary.Integer, int32(-5), `-5`},
+		{binary.Long, 1 << 60, `1152921504606846976`},
+		{binary.Float, float32(1.5), `1.5`},
+		{binary.Double, 0.1, `0.1`},
+		{binary.String, "a\"b", `"a\"b"`},
+		{binary.Bytes, []byte{0, 'a', 0xFF}, `"\u0000aÿ"`},
+		{binary.FixedSchema{Name: "f", Size: 2}, []byte{1, 2}, `"\u0001\u0002"`},
+		{binary.EnumSchema{Name: "e", Symbols: []string{"A", "B"}}, "B", `"B"`},
+		{binary.ArraySchema{ItemSchema: binary.Integer}, []interface{}{int32(1), int32(2)}, `[1,2]`},
+		{binary.MapSchema{ValueSchema: binary.String}, map[string]interface{}{"b": "x", "a": "y"}, `{"a":"y","b":"x"}`},
+	}
+	for _, c := range cases {
+		data, err := Marshal(c.schema, c.value)
+		assert.NoError(t, err, c.json)
+		assert.Equal(t, c.json, string(data))
+		v, err := Unmarshal(data, c.schema)
+		assert.NoError(t, err, c.json)
+		assert.Equal(t, c.value, v)
+	}
+}
+
+func TestRecordAndUnion(t *testing.T) {
+	schema := parseSchema(t, `{"type": "record", "name": "r", "namespace": "ns", "fields": [
+		{"name": "id", "type": "long"},
+		{"name": "tag", "type": ["null", "string", "ns.r", {"type": "array", "items": "int"}]},
+		{"name": "extra", "type": "int", "default": 7}
+	]}`)
+	rec := avro.Record{Schema: schema, Values: []interface{}{1, avro.Record{Schema: schema, Values: []interface{}{2, nil, int32(0)}}, int32(3)}}
+	data, err := Marshal(schema, rec)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":1,"tag":{"ns.r":{"id":2,"tag":null,"extra":0}},"extra":3}`, string(data))
+	v, err := Unmarshal(data, schema)
+	assert.NoError(t, err)
+	assert.Equal(t, rec.Values[0], v.(avro.Record).Values[0])
+	assert.Equal(t, []interface{}{2, nil, int32(0)}, v.(avro.Record).Values[1].(avro.Record).Values)
+
+	v, err = Unmarshal([]byte(`{"id": 5, "tag": {"array": [1]}}`), schema)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{5, []interface{}{int32(1)}, int32(7)}, v.(avro.Record).Values)
+
+	data, err = Marshal(schema, avro.Record{Values: []interface{}{1, "x"}})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":1,"tag":{"string":"x"},"extra":7}`, string(data))
+}
+
+func TestUnionFixed(t *testing.T) {
+	schema := parseSchema(t, `["null", {"type": "fixed", "name": "md5", "size": 2}]`)
+	data, err := Marshal(schema, []byte{1, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"md5":"\u0001\u0002"}`, string(data))
+	v, err := Unmarshal(data, schema)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 2}, v)
+}
+
+func TestErrorPaths(t *testing.T) {
+	schema := parseSchema(t, `{"type": "map", "values": {"type": "array", "items": "int"}}`)
+	_, err := Unmarshal([]byte(`{"a": [1, "x"]}`), schema)
+	var perr *avro.PathError
+	assert.ErrorAs(t, err, &perr)
+	assert.Equal(t, []string{binary.KeyPath("a"), binary.IndexPath(1)}, perr.Path)
+	_, err = Marshal(schema, map[string]interface{}{"a": []interface{}{int32(1), "x"}})
+	assert.ErrorAs(t, err, &perr)
+	assert.Equal(t, []string{"[a]", "[1]"}, perr.Path)
+}
+
+func TestErrors(t *testing.T) {
+	schema := parseSchema(t, `{"type": "record", "name": "r", "fields": [
+		{"name": "id", "type": "int"},
+		{"name": "tag", "type": ["null", "string"]}
+	]}`)
+	for _, j := range []string{
+		`{"id": 1.5, "tag": null}`,
+		`{"id": 4294967296, "tag": null}`,
+		`{"tag": null}`,
+		`{"id": 1, "tag": "x"}`,
+		`{"id": 1, "tag": {"int": 1}}`,
+	} {
+		_, err := Unmarshal([]byte(j), schema)
+		assert.Error(t, err, j)
+	}
+	_, err := Unmarshal([]byte(`{"id": 1, "tag": {"int": 1}}`), schema)
+	assert.EqualError(t, err, "tag: ValueError. Expect union, found map[int:1] of type map[string]interface {}")
+	_, err = Unmarshal([]byte(`"Ā"`), binary.Bytes)
+	assert.Error(t, err)
+	_, err = Marshal(schema, avro.Record{Values: []interface{}{int32(1), 2}})
+	assert.Error(t, err)
+}
+
+func TestDecoder(t *testing.T) {
+	d := NewDecoder(strings.NewReader("1\n2\n"))
+	for _, expected := range []int{1, 2} {
+		v, err := d.Decode(binary.Long)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, v)
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, Encode(&buf, binary.Long, 3))
+	assert.Equal(t, "3", buf.String())
+}
@@ -0,0 +1,254 @@
+package ocf
+
+import (
+	"bytes"
+	"compress/flate"
+	benc "encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/galtsev/avro/binary"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// CodecError reports codec named in the file header which is not supported.
+type CodecError struct {
+	Name string
+}
+
+func (err CodecError) Error() string {
+	return fmt.Sprintf("ocf: unsupported codec %q", err.Name)
+}
+
+// Codec compresses data blocks of object container files.
+// Codecs are selected by the avro.codec header and must be safe for concurrent use.
+type Codec interface {
+	Compress(block []byte) ([]byte, error)
+	Decompress(block []byte) ([]byte, error)
+}
+
+var (
+	codecsLock sync.RWMutex
+	codecs     = map[string]Codec{
+		"null":      nullCodec{},
+		"deflate":   deflateCodec{},
+		"snappy":    snappyCodec{},
+		"zstandard": &zstdCodec{},
+	}
+)
+
+// RegisterCodec makes codec available to Reader and Writer under name,
+// replacing any codec registered with that name before.
+func RegisterCodec(name string, codec Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[name] = codec
+}
+
+func getCodec(name string) (Codec, error) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		return nil, CodecError{Name: name}
+	}
+	return c, nil
+}
+
+// LimitedCodec is implemented by codecs able to stop decompressing
+// when data grows beyond limit, defending against decompression bombs.
+// DecompressLimit may fail or return data longer than limit once the limit is exceeded,
+// but shouldn't decompress much past it. Reader with MaxBlockSize set
+// accepts only files written with codecs implementing LimitedCodec.
+type LimitedCodec interface {
+	Codec
+	DecompressLimit(block []byte, limit int) ([]byte, error)
+}
+
+func decompressLimit(c Codec, block []byte, limit int) ([]byte, error) {
+	if limit <= 0 {
+		return c.Decompress(block)
+	}
+	lc, ok := c.(LimitedCodec)
+	if !ok {
+		return nil, errUnlimitedCodec
+	}
+	res, err := lc.DecompressLimit(block, limit)
+	if err == nil && len(res) > limit {
+		return nil, blockSizeError(len(res), limit)
+	}
+	return res, err
+}
+
+var errUnlimitedCodec = errors.New("ocf: codec can't limit size of decompressed blocks")
+
+func blockSizeError(size, limit int) error {
+	return binary.LimitError{Kind: "block", Length: size, Limit: limit}
+}
+
+type nullCodec struct{}
+
+func (nullCodec) Compress(block []byte) ([]byte, error) {
+	return block, nil
+}
+
+func (nullCodec) Decompress(block []byte) ([]byte, error) {
+	return block, nil
+}
+
+// DecompressLimit doesn't allocate, data size is checked by the caller
+func (nullCodec) DecompressLimit(block []byte, limit int) ([]byte, error) {
+	return block, nil
+}
+
+// deflateCodec writes raw deflate data without zlib header, as the spec requires
+type deflateCodec struct{}
+
+func (deflateCodec) Compress(block []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(block); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (deflateCodec) Decompress(block []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(block))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (deflateCodec) DecompressLimit(block []byte, limit int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(block))
+	defer r.Close()
+	// one byte over the limit is enough to detect it is exceeded
+	return io.ReadAll(io.LimitReader(r, int64(limit)+1))
+}
+
+// ChecksumError reports block which doesn't match its checksum.
+type ChecksumError struct {
+	Expected uint32
+	Actual   uint32
+}
+
+func (err ChecksumError) Error() string {
+	return fmt.Sprintf("ocf: block checksum mismatch, expected %08x, found %08x", err.Expected, err.Actual)
+}
+
+// snappyCodec writes snappy compressed block followed by big-endian CRC32 of uncompressed data
+type snappyCodec struct{}
+
+func (snappyCodec) Compress(block []byte) ([]byte, error) {
+	res := snappy.Encode(nil, block)
+	return benc.BigEndian.AppendUint32(res, crc32.ChecksumIEEE(block)), nil
+}
+
+func (c snappyCodec) DecompressLimit(block []byte, limit int) ([]byte, error) {
+	if len(block) >= 4 {
+		size, err := snappy.DecodedLen(block[:len(block)-4])
+		if err != nil {
+			return nil, err
+		}
+		if size > limit {
+			return nil, blockSizeError(size, limit)
+		}
+	}
+	return c.Decompress(block)
+}
+
+func (snappyCodec) Decompress(block []byte) ([]byte, error) {
+	if len(block) < 4 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data := block[:len(block)-4]
+	expected := benc.BigEndian.Uint32(block[len(block)-4:])
+	res, err := snappy.Decode(nil, data)
+	if err != nil {
+		return nil, err
+	}
+	if actual := crc32.ChecksumIEEE(res); actual != expected {
+		return nil, ChecksumError{Expected: expected, Actual: actual}
+	}
+	return res, nil
+}
+
+// zstdCodec shares one encoder and decoder, both safe for concurrent EncodeAll/DecodeAll.
+// They are created on first use, so programs not reading zstandard files don't pay for them.
+type zstdCodec struct {
+	once    sync.Once
+	err     error
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	// decoder failing DecodeAll when output outgrows the destination capacity
+	limited *zstd.Decoder
+	// streaming decoders, for frames not declaring their size
+	streams sync.Pool
+}
+
+func (c *zstdCodec) init() error {
+	c.once.Do(func() {
+		if c.encoder, c.err = zstd.NewWriter(nil); c.err != nil {
+			return
+		}
+		if c.decoder, c.err = zstd.NewReader(nil); c.err != nil {
+			return
+		}
+		c.limited, c.err = zstd.NewReader(nil, zstd.WithDecodeAllCapLimit(true))
+	})
+	return c.err
+}
+
+func (c *zstdCodec) Compress(block []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c.encoder.EncodeAll(block, nil), nil
+}
+
+func (c *zstdCodec) Decompress(block []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c.decoder.DecodeAll(block, nil)
+}
+
+func (c *zstdCodec) DecompressLimit(block []byte, limit int) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	var header zstd.Header
+	if header.Decode(block) == nil && header.HasFCS {
+		if header.FrameContentSize > uint64(limit) {
+			return nil, blockSizeError(int(header.FrameContentSize), limit)
+		}
+		// the block is usually a single frame, of the declared size
+		res, err := c.limited.DecodeAll(block, make([]byte, 0, header.FrameContentSize))
+		if err != zstd.ErrDecoderSizeExceeded {
+			return res, err
+		}
+	}
+	// frames written by streaming encoders don't declare their size,
+	// stream them to stop one byte past the limit
+	dec, _ := c.streams.Get().(*zstd.Decoder)
+	if dec == nil {
+		var err error
+		if dec, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true)); err != nil {
+			return nil, err
+		}
+	}
+	if err := dec.Reset(bytes.NewReader(block)); err != nil {
+		return nil, err
+	}
+	defer c.streams.Put(dec)
+	return io.ReadAll(io.LimitReader(dec, int64(limit)+1))
+}
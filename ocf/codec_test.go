@@ -0,0 +1,185 @@
+package ocf
+
+import (
+	"bytes"
+	"errors"
+	"github.com/galtsev/avro"
+	"github.com/galtsev/avro/binary"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+const testSchema = `{
+    "name": "rec",
+    "type": "record",
+    "fields": [
+        {"name": "id", "type": "long"},
+        {"name": "name", "type": "string"}
+    ]
+}`
+
+func testRecords(n int) []interface{} {
+	var res []interface{}
+	for i := 0; i < n; i++ {
+		res = append(res, avro.Record{Values: []interface{}{i, "record number"}})
+	}
+	return res
+}
+
+func newWriter(w io.Writer) *Writer {
+	res, err := NewWriter(w, testSchema)
+	check(err)
+	return res
+}
+
+// write records to a new file in memory
+func writeFile(records []interface{}, setup func(w *Writer)) []byte {
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	if setup != nil {
+		setup(w)
+	}
+	for _, rec := range records {
+		check(w.Write(rec))
+	}
+	check(w.Close())
+	return buf.Bytes()
+}
+
+func openFile(data []byte) *Reader {
+	r, err := NewReader(bytes.NewBuffer(data))
+	check(err)
+	return r
+}
+
+func readFile(data []byte) []interface{} {
+	r := openFile(data)
+	var res []interface{}
+	for r.NextBatch() {
+		for r.Batch().Next() {
+			res = append(res, avro.Record{Values: r.Batch().Value.(avro.Record).Values})
+		}
+	}
+	check(r.Err())
+	return res
+}
+
+// size of file header, including sync marker
+func headerSize(data []byte) int {
+	return int(openFile(data).reader.offset)
+}
+
+func TestCodecs(t *testing.T) {
+	records := testRecords(250)
+	plain := writeFile(records, nil)
+	assert.Equal(t, records, readFile(plain))
+	for _, name := range []string{"deflate", "snappy", "zstandard"} {
+		compressed := writeFile(records, func(w *Writer) {
+			w.Codec = name
+			w.BatchSize = 100
+		})
+		assert.True(t, len(compressed) < len(plain)/2, name)
+		assert.Equal(t, records, readFile(compressed), name)
+	}
+}
+
+func TestSnappyChecksum(t *testing.T) {
+	c, err := getCodec("snappy")
+	assert.NoError(t, err)
+	block, err := c.Compress([]byte("some block data"))
+	assert.NoError(t, err)
+	data, err := c.Decompress(block)
+	assert.NoError(t, err)
+	assert.Equal(t, "some block data", string(data))
+	block[len(block)-1] ^= 0xFF
+	_, err = c.Decompress(block)
+	assert.IsType(t, ChecksumError{}, err)
+}
+
+// xorCodec is a toy codec to test registration
+type xorCodec struct{}
+
+func (xorCodec) Compress(block []byte) ([]byte, error) {
+	res := make([]byte, len(block))
+	for i, b := range block {
+		res[i] = b ^ 0x5A
+	}
+	return res, nil
+}
+
+func (c xorCodec) Decompress(block []byte) ([]byte, error) {
+	return c.Compress(block)
+}
+
+func TestRegisterCodec(t *testing.T) {
+	RegisterCodec("xor", xorCodec{})
+	records := testRecords(10)
+	data := writeFile(records, func(w *Writer) { w.Codec = "xor" })
+	assert.False(t, bytes.Contains(data, []byte("record number")))
+	assert.Equal(t, records, readFile(data))
+
+	// size of decompressed blocks can't be limited
+	r := openFile(data)
+	r.Limits.MaxBlockSize = 1 << 20
+	assert.False(t, r.NextBatch())
+	assert.Equal(t, errUnlimitedCodec, r.Err())
+}
+
+func TestZstdStreamLimit(t *testing.T) {
+	// frame content size is unknown when the encoder flushes the first block
+	var block bytes.Buffer
+	enc, err := zstd.NewWriter(&block)
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = enc.Write(make([]byte, 50000))
+		assert.NoError(t, err)
+		assert.NoError(t, enc.Flush())
+	}
+	assert.NoError(t, enc.Close())
+	var header zstd.Header
+	assert.NoError(t, header.Decode(block.Bytes()))
+	assert.False(t, header.HasFCS)
+
+	c, err := getCodec("zstandard")
+	assert.NoError(t, err)
+	data, err := decompressLimit(c, block.Bytes(), 100000)
+	assert.NoError(t, err)
+	assert.Len(t, data, 100000)
+	_, err = decompressLimit(c, block.Bytes(), 1000)
+	assert.Equal(t, blockSizeError(1001, 1000), err)
+
+	// frames declaring their size may be followed by more frames
+	first, err := c.Compress(make([]byte, 600))
+	assert.NoError(t, err)
+	empty, err := c.Compress(nil)
+	assert.NoError(t, err)
+	frames := append(append(append([]byte(nil), first...), empty...), first...)
+	data, err = decompressLimit(c, frames, 1200)
+	assert.NoError(t, err)
+	assert.Len(t, data, 1200)
+	_, err = decompressLimit(c, frames, 1000)
+	assert.Equal(t, blockSizeError(1001, 1000), err)
+	data, err = decompressLimit(c, empty, 1000)
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestUnknownCodec(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("Obj\x01")
+	header := map[string]interface{}{"avro.schema": []byte(testSchema), "avro.codec": []byte("lzma")}
+	assert.NoError(t, binary.MapSchema{ValueSchema: binary.Bytes}.Encode(&buf, header))
+	buf.Write(make([]byte, 16))
+	_, err := NewReader(&buf)
+	var cerr CodecError
+	assert.True(t, errors.As(err, &cerr))
+	assert.Equal(t, "lzma", cerr.Name)
+	assert.EqualError(t, err, `ocf: unsupported codec "lzma"`)
+
+	w := newWriter(&bytes.Buffer{})
+	w.Codec = "lzma"
+	assert.True(t, errors.As(w.Close(), &cerr))
+	assert.Equal(t, "lzma", cerr.Name)
+}
@@ -0,0 +1,114 @@
+package ocf
+
+import (
+	"fmt"
+	"iter"
+)
+
+// RecordError reports record which can't be decoded.
+type RecordError struct {
+	// Block is the index of the block among those read by the iterator, starting from 0
+	Block int
+	// Offset of the block start in the file, as returned by Reader.BlockOffset
+	Offset int64
+	// Record is the index of the record in the block
+	Record int
+	Err    error
+}
+
+func (err RecordError) Error() string {
+	return fmt.Sprintf("ocf: block %d at offset %d, record %d: %v", err.Block, err.Offset, err.Record, err.Err)
+}
+
+func (err RecordError) Unwrap() error {
+	return err.Err
+}
+
+// Iterator reads records of a file one by one, hiding block boundaries:
+//
+//	it := r.Iterator()
+//	for it.Next() {
+//		v := it.Value()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Next returns false on the first error.
+type Iterator struct {
+	r      *Reader
+	batch  *Batch
+	block  int
+	record int
+	value  interface{}
+	err    error
+}
+
+// Iterator returns iterator over records, starting from the current position of r.
+func (r *Reader) Iterator() *Iterator {
+	return &Iterator{r: r, block: -1}
+}
+
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	for it.batch == nil || it.batch.recsInBuffer == 0 {
+		ok, err := it.nextBatch()
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if !ok {
+			it.batch = nil
+			return false
+		}
+	}
+	v, err := it.batch.next()
+	if err != nil {
+		it.err = RecordError{Block: it.block, Offset: it.batch.offset, Record: it.record, Err: err}
+		return false
+	}
+	it.value = v
+	it.record += 1
+	return true
+}
+
+func (it *Iterator) nextBatch() (bool, error) {
+	if !it.r.NextBatch() {
+		return false, it.r.Err()
+	}
+	it.batch = it.r.Batch()
+	it.block += 1
+	it.record = 0
+	return true, nil
+}
+
+// Value returns record read by the last call to Next.
+func (it *Iterator) Value() interface{} {
+	return it.value
+}
+
+// Err returns error which stopped iteration, nil at the end of file.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// All returns iterator over records for use in range loop.
+// An error is yielded with nil value and ends the iteration.
+// Breaking out of the loop stops reading ahead when Concurrency is set:
+// records after the last one yielded, including blocks read ahead, are dropped.
+func (r *Reader) All() iter.Seq2[interface{}, error] {
+	return func(yield func(interface{}, error) bool) {
+		it := r.Iterator()
+		for it.Next() {
+			if !yield(it.Value(), nil) {
+				r.stopPrefetch()
+				return
+			}
+		}
+		if it.err != nil {
+			yield(nil, it.err)
+		}
+	}
+}
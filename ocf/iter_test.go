@@ -0,0 +1,73 @@
+package ocf
+
+import (
+	"bytes"
+	"github.com/galtsev/avro"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestIterator(t *testing.T) {
+	records := testRecords(25)
+	data := writeFile(records, func(w *Writer) { w.BatchSize = 10 })
+	it := openFile(data).Iterator()
+	var res []interface{}
+	for it.Next() {
+		res = append(res, avro.Record{Values: it.Value().(avro.Record).Values})
+	}
+	assert.NoError(t, it.Err())
+	assert.Equal(t, records, res)
+	assert.False(t, it.Next())
+}
+
+func TestIteratorErrors(t *testing.T) {
+	data := writeFile(testRecords(25), func(w *Writer) { w.BatchSize = 10 })
+	_, offsets := readBlocks(openSeekable(data))
+
+	// negative string length in the third record of the second block
+	corrupt := append([]byte(nil), data...)
+	block := data[offsets[1]:]
+	pos := bytes.Index(block[2:], []byte("\x18\x1arecord number")) + 2 + int(offsets[1])
+	corrupt[pos+1] = 0x7F
+	it := openFile(corrupt).Iterator()
+	n := 0
+	for it.Next() {
+		n += 1
+	}
+	assert.Equal(t, 12, n)
+	var err RecordError
+	assert.ErrorAs(t, it.Err(), &err)
+	assert.Equal(t, 1, err.Block)
+	assert.Equal(t, offsets[1], err.Offset)
+	assert.Equal(t, 2, err.Record)
+
+	corrupt = append([]byte(nil), data...)
+	corrupt[offsets[2]-1] ^= 0xFF
+	it = openFile(corrupt).Iterator()
+	for it.Next() {
+	}
+	assert.IsType(t, CorruptionError{}, it.Err())
+}
+
+func TestAll(t *testing.T) {
+	records := testRecords(25)
+	data := writeFile(records, func(w *Writer) { w.BatchSize = 10 })
+	var res []interface{}
+	for v, err := range openFile(data).All() {
+		assert.NoError(t, err)
+		res = append(res, avro.Record{Values: v.(avro.Record).Values})
+		if len(res) == 15 {
+			break
+		}
+	}
+	assert.Equal(t, records[:15], res)
+
+	data[len(data)-1] ^= 0xFF
+	var errs []error
+	for _, err := range openFile(data).All() {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	assert.Len(t, errs, 1)
+}
@@ -0,0 +1,169 @@
+package ocf
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// prefetcher reads blocks ahead of NextBatch, decompressing and decoding them in parallel
+type prefetcher struct {
+	// blocks in file order
+	blocks chan *prefetched
+	stop   chan struct{}
+}
+
+type prefetched struct {
+	batch *Batch
+	// error reading or decompressing the block
+	err  error
+	done chan struct{}
+}
+
+func (r *Reader) startPrefetch() {
+	p := &prefetcher{
+		blocks: make(chan *prefetched, r.Concurrency),
+		stop:   make(chan struct{}),
+	}
+	r.prefetch = p
+	workers := make(chan struct{}, r.Concurrency)
+	go func() {
+		defer close(p.blocks)
+		for {
+			item := &prefetched{done: make(chan struct{})}
+			var err error
+			item.batch, err = r.nextBlock(nil)
+			if err == io.EOF {
+				return
+			}
+			// reading error ends the stream, check it before the worker may set item.err
+			item.err = err
+			failed := err != nil
+			if !failed {
+				workers <- struct{}{}
+				go func() {
+					defer func() {
+						<-workers
+						close(item.done)
+					}()
+					if err := r.decompress(item.batch); err != nil {
+						item.err = CorruptionError{Offset: item.batch.offset, Err: err}
+						return
+					}
+					item.batch.decode()
+				}()
+			} else {
+				close(item.done)
+			}
+			select {
+			case p.blocks <- item:
+			case <-p.stop:
+				return
+			}
+			if failed {
+				return
+			}
+		}
+	}()
+}
+
+func (r *Reader) nextPrefetched() bool {
+	if r.prefetch == nil {
+		r.startPrefetch()
+	}
+	for item := range r.prefetch.blocks {
+		<-item.done
+		if item.err != nil {
+			if item.batch != nil && r.Recover {
+				r.skip(item.batch.size)
+				continue
+			}
+			r.err = item.err
+			return false
+		}
+		r.batch = item.batch
+		r.blockOffset = item.batch.offset
+		return true
+	}
+	return false
+}
+
+// stopPrefetch waits for the reading goroutine to exit, dropping blocks read ahead
+func (r *Reader) stopPrefetch() {
+	if r.prefetch == nil {
+		return
+	}
+	close(r.prefetch.stop)
+	for item := range r.prefetch.blocks {
+		<-item.done
+	}
+	r.prefetch = nil
+}
+
+// Close stops goroutines reading ahead when Concurrency is set.
+// It doesn't close the underlying reader.
+func (r *Reader) Close() error {
+	r.stopPrefetch()
+	return nil
+}
+
+// compressed block, written by writeLoop in order of Flush calls
+type pendingBlock struct {
+	count int
+	block []byte
+	err   error
+	done  chan struct{}
+}
+
+// queueBlock compresses buffered records in background and queues the block for writing
+func (fw *Writer) queueBlock() {
+	if fw.queue == nil {
+		fw.queue = make(chan *pendingBlock, fw.Concurrency)
+		go fw.writeLoop()
+	}
+	b := &pendingBlock{count: fw.recsInBuffer, done: make(chan struct{})}
+	data := bytes.Clone(fw.buf.Bytes())
+	fw.pending.Add(1)
+	fw.queue <- b
+	go func() {
+		defer close(b.done)
+		b.block, b.err = fw.codec.Compress(data)
+	}()
+}
+
+func (fw *Writer) writeLoop() {
+	for b := range fw.queue {
+		<-b.done
+		err := b.err
+		if err == nil && fw.asyncError() == nil {
+			err = fw.writeBlock(b.count, b.block)
+		}
+		if err != nil {
+			fw.setAsyncError(err)
+		}
+		fw.pending.Done()
+	}
+}
+
+// asyncError returns the first error compressing or writing queued block
+func (fw *Writer) asyncError() error {
+	fw.errLock.Lock()
+	defer fw.errLock.Unlock()
+	return fw.err
+}
+
+func (fw *Writer) setAsyncError(err error) {
+	fw.errLock.Lock()
+	defer fw.errLock.Unlock()
+	if fw.err == nil {
+		fw.err = err
+	}
+}
+
+// pipeline state of Writer
+type writerPipeline struct {
+	queue   chan *pendingBlock
+	pending sync.WaitGroup
+	errLock sync.Mutex
+	err     error
+}
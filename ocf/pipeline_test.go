@@ -0,0 +1,104 @@
+package ocf
+
+import (
+	"errors"
+	"github.com/galtsev/avro"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConcurrentWriter(t *testing.T) {
+	records := testRecords(1000)
+	for _, codec := range []string{"null", "deflate", "zstandard"} {
+		data := writeFile(records, func(w *Writer) {
+			w.Codec = codec
+			w.BatchSize = 7
+			w.Concurrency = 4
+		})
+		assert.Equal(t, records, readFile(data), codec)
+	}
+
+	fw := &failingWriter{limit: 1000}
+	w := newWriter(fw)
+	w.Concurrency = 4
+	w.BatchSize = 10
+	var err error
+	for _, rec := range testRecords(1000) {
+		if err = w.Write(rec); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	assert.EqualError(t, err, "disk full")
+}
+
+func TestConcurrentReader(t *testing.T) {
+	records := testRecords(1000)
+	data := writeFile(records, func(w *Writer) {
+		w.Codec = "snappy"
+		w.BatchSize = 7
+	})
+	r := openSeekable(data)
+	r.Concurrency = 4
+	res, offsets := readBlocks(r)
+	assert.Equal(t, records, res)
+
+	// seek while blocks are read ahead
+	r = openSeekable(data)
+	r.Concurrency = 4
+	assert.True(t, r.NextBatch())
+	assert.NoError(t, r.SeekBlock(offsets[100]))
+	res, _ = readBlocks(r)
+	assert.Equal(t, records[700:], res)
+
+	r = openFile(data)
+	r.Concurrency = 4
+	var n int
+	for range r.All() {
+		if n += 1; n == 10 {
+			break
+		}
+	}
+	// the goroutine reading ahead has exited
+	assert.Nil(t, r.prefetch)
+}
+
+func TestConcurrentReaderErrors(t *testing.T) {
+	records := testRecords(100)
+	data := writeFile(records, func(w *Writer) {
+		w.Codec = "snappy"
+		w.BatchSize = 10
+	})
+	_, offsets := readBlocks(openSeekable(data))
+	// break checksum of the third block
+	data[offsets[3]-17] ^= 0xFF
+
+	r := openFile(data)
+	r.Concurrency = 4
+	var n int
+	var err error
+	for _, err = range r.All() {
+		if err != nil {
+			break
+		}
+		n += 1
+	}
+	assert.Equal(t, 20, n)
+	var cerr ChecksumError
+	assert.True(t, errors.As(err, &cerr))
+
+	r = openFile(data)
+	r.Concurrency = 4
+	r.Recover = true
+	var res []interface{}
+	for v, err := range r.All() {
+		assert.NoError(t, err)
+		res = append(res, avro.Record{Values: v.(avro.Record).Values})
+	}
+	assert.Equal(t, append(records[:20:20], records[30:]...), res)
+	skippedBytes, skippedBlocks := r.Skipped()
+	assert.Equal(t, offsets[3]-offsets[2], skippedBytes)
+	assert.Equal(t, 1, skippedBlocks)
+}
@@ -2,76 +2,426 @@ package ocf
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/galtsev/avro"
 	"github.com/galtsev/avro/binary"
 	"io"
+	"math"
+	"sync/atomic"
+)
+
+// CorruptionError reports data block which can't be read: malformed block header,
+// truncated data, codec failure or sync marker not matching the file header.
+type CorruptionError struct {
+	// Offset of the block start in the file
+	Offset int64
+	Err    error
+}
+
+func (err CorruptionError) Error() string {
+	return fmt.Sprintf("ocf: corrupt block at offset %d: %v", err.Offset, err.Err)
+}
+
+func (err CorruptionError) Unwrap() error {
+	return err.Err
+}
+
+// Limits protect Reader from hostile input. Blocks exceeding them are corrupt,
+// values exceeding embedded binary.Limits fail to decode. Zero fields mean no limit.
+type Limits struct {
+	// size of block data, both compressed and decompressed.
+	// Files compressed with codecs not implementing LimitedCodec can't be read with it.
+	MaxBlockSize    int
+	MaxBlockRecords int
+	binary.Limits
+}
+
+var (
+	errBadMagic     = fmt.Errorf("ocf: not an object container file")
+	errNoSchema     = fmt.Errorf("ocf: file header has no avro.schema")
+	errSyncMismatch = fmt.Errorf("sync marker mismatch")
+	errSyncInData   = fmt.Errorf("sync marker inside block data")
 )
 
 type Reader struct {
-	reader avro.Reader
-	schema avro.Schema
-	batch  *Batch
+	reader   *stream
+	schema   avro.Schema
+	codec    Codec
+	sync     [16]byte
+	metadata map[string][]byte
+	batch    *Batch
+	// set if reader supports SeekBlock and Sync
+	seeker      io.ReadSeeker
+	blockOffset int64
+	// blocks whose sync marker starts at or after end are not read
+	end int64
+	// Recover makes NextBatch skip corrupt blocks, scanning forward
+	// to the next sync marker, instead of stopping with CorruptionError.
+	Recover bool
+	// Concurrency is the number of blocks decompressed and decoded in parallel,
+	// ahead of NextBatch. Values of 0 and 1 mean blocks are read on demand,
+	// by the goroutine calling NextBatch. Set it before the first NextBatch.
+	Concurrency int
+	// Limits are checked while reading blocks. Set them before the first NextBatch.
+	Limits        Limits
+	prefetch      *prefetcher
+	skippedBytes  atomic.Int64
+	skippedBlocks atomic.Int64
+	// error which stopped NextBatch
+	err error
 }
 
 func (b *Reader) Batch() *Batch {
 	return b.batch
 }
 
+// Metadata returns all entries of the file header, including avro.schema and avro.codec.
+func (r *Reader) Metadata() map[string][]byte {
+	res := make(map[string][]byte, len(r.metadata))
+	for k, v := range r.metadata {
+		res[k] = v
+	}
+	return res
+}
+
+// Skipped returns number of bytes and corrupt blocks skipped in recovery mode.
+func (r *Reader) Skipped() (bytes int64, blocks int) {
+	return r.skippedBytes.Load(), int(r.skippedBlocks.Load())
+}
+
+// skip counts corrupt block of size bytes
+func (r *Reader) skip(size int64) {
+	r.skippedBytes.Add(size)
+	r.skippedBlocks.Add(1)
+}
+
 type Batch struct {
 	buf          bytes.Buffer
 	schema       avro.Schema
 	recsInBuffer int
 	Value        interface{}
+	limits       binary.Limits
+	// offset and size of the block in the file
+	offset int64
+	size   int64
+	// set if records are decoded in advance: values and error decoding the next one
+	decoded bool
+	values  []interface{}
+	err     error
+}
+
+func NewReader(r avro.Reader) (*Reader, error) {
+	return NewReaderWithSchema(r, nil)
 }
 
-func NewReader(r avro.Reader) *Reader {
-	res := Reader{reader: r}
+// NewReaderWithSchema creates reader returning values of readerSchema,
+// resolved from the schema the file was written with.
+// If readerSchema is nil, values are returned as written.
+func NewReaderWithSchema(r avro.Reader, readerSchema avro.Schema) (*Reader, error) {
+	res := Reader{reader: &stream{r: r}, end: math.MaxInt64}
 	var magic [4]byte
-	_, err := r.Read(magic[:])
-	check(err)
+	if _, err := io.ReadFull(res.reader, magic[:]); err != nil {
+		return nil, err
+	}
+	if string(magic[:]) != "Obj\x01" {
+		return nil, errBadMagic
+	}
 	headerSchema := binary.MapSchema{ValueSchema: binary.Bytes}
-	header := headerSchema.Decode(r).(map[string]interface{})
-	jschema := string(header["avro.schema"].([]byte))
-	repo := binary.NewRepo()
-	res.schema = repo.Append(jschema)
-	var sync [16]byte
-	_, err = r.Read(sync[:])
-	check(err)
-	return &res
+	decoded, err := headerSchema.Decode(res.reader)
+	if err != nil {
+		return nil, err
+	}
+	header := decoded.(map[string]interface{})
+	res.metadata = make(map[string][]byte, len(header))
+	for k, v := range header {
+		res.metadata[k] = v.([]byte)
+	}
+	// absent codec means "null"
+	codecName := "null"
+	if name, ok := res.metadata["avro.codec"]; ok {
+		codecName = string(name)
+	}
+	if res.codec, err = getCodec(codecName); err != nil {
+		return nil, err
+	}
+	jschema, ok := res.metadata["avro.schema"]
+	if !ok {
+		return nil, errNoSchema
+	}
+	if res.schema, err = binary.NewRepo().Append(string(jschema)); err != nil {
+		return nil, err
+	}
+	if readerSchema != nil {
+		if res.schema, err = binary.Resolve(res.schema, readerSchema); err != nil {
+			return nil, err
+		}
+	}
+	if _, err = io.ReadFull(res.reader, res.sync[:]); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// NextBatch reads next data block, returning false at the end of file or on error.
+// Corrupt block stops reading with CorruptionError, returned by Err, unless Recover is set.
+func (r *Reader) NextBatch() bool {
+	if r.err != nil {
+		return false
+	}
+	if _, ok := r.codec.(LimitedCodec); !ok && r.Limits.MaxBlockSize > 0 {
+		r.err = errUnlimitedCodec
+		return false
+	}
+	if r.Concurrency > 1 {
+		return r.nextPrefetched()
+	}
+	batch, err := r.nextBlock(r.decompress)
+	if err != nil {
+		if err != io.EOF {
+			r.err = err
+		}
+		return false
+	}
+	r.batch = batch
+	r.blockOffset = batch.offset
+	return true
 }
 
-func (r *Reader) NextBatch() (ok bool) {
-	defer func() {
-		err := recover()
+// Err returns error which stopped NextBatch, nil at the end of file.
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// nextBlock reads next block and applies process to it, if not nil.
+// Blocks failing to read or process are corrupt. Returns io.EOF at the end of file.
+func (r *Reader) nextBlock(process func(*Batch) error) (*Batch, error) {
+	for {
+		start := r.reader.offset
+		if start-16 >= r.end {
+			return nil, io.EOF
+		}
+		if r.Recover {
+			r.reader.record = &bytes.Buffer{}
+		}
+		batch, err := r.readBlock()
+		if err == nil && process != nil {
+			err = process(batch)
+		}
+		raw := r.reader.record
+		r.reader.record = nil
 		if err == nil {
-			ok = true
-			return
+			batch.offset = start
+			batch.size = r.reader.offset - start
+			return batch, nil
+		}
+		if err == io.EOF && r.reader.offset == start {
+			return nil, io.EOF
 		}
 		if err == io.EOF {
-			ok = false
-			return
-		}
-		panic(err)
-	}()
-	batch := Batch{schema: r.schema}
-	r.batch = &batch
-	batch.recsInBuffer = binary.DecodeVarInt(r.reader)
-	blockLen := binary.DecodeVarInt(r.reader)
-	buf := make([]byte, blockLen)
-	_, err := io.ReadFull(r.reader, buf)
-	check(err)
+			err = io.ErrUnexpectedEOF
+		}
+		if !r.Recover {
+			return nil, CorruptionError{Offset: start, Err: err}
+		}
+		if err = r.resync(start, raw.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readBlock reads block with compressed data
+func (r *Reader) readBlock() (*Batch, error) {
+	batch := Batch{schema: r.schema, limits: r.Limits.Limits}
+	var err error
+	batch.recsInBuffer, err = binary.DecodeVarInt(r.reader)
+	if err != nil {
+		return nil, err
+	}
+	if batch.recsInBuffer < 0 {
+		return nil, fmt.Errorf("negative record count %d", batch.recsInBuffer)
+	}
+	if limit := r.Limits.MaxBlockRecords; limit > 0 && batch.recsInBuffer > limit {
+		return nil, binary.LimitError{Kind: "block records", Length: batch.recsInBuffer, Limit: limit}
+	}
+	blockLen, err := binary.DecodeVarInt(r.reader)
+	if err != nil {
+		return nil, err
+	}
+	if blockLen < 0 {
+		return nil, fmt.Errorf("negative block size %d", blockLen)
+	}
+	if limit := r.Limits.MaxBlockSize; limit > 0 && blockLen > limit {
+		return nil, blockSizeError(blockLen, limit)
+	}
+	buf, err := r.readData(blockLen)
+	if err != nil {
+		return nil, err
+	}
 	var sync [16]byte
-	_, err = io.ReadFull(r.reader, sync[:])
-	check(err)
+	if _, err = io.ReadFull(r.reader, sync[:]); err != nil {
+		return nil, err
+	}
+	if sync != r.sync {
+		return nil, errSyncMismatch
+	}
 	batch.buf = *bytes.NewBuffer(buf)
-	return true
+	return &batch, nil
+}
+
+// readData reads n bytes of block data. Corrupt file may claim any size,
+// so n isn't trusted for allocation. In recovery mode reading also stops at sync marker
+// found inside the data, rather than pulling the rest of the file into memory.
+func (r *Reader) readData(n int) ([]byte, error) {
+	if !r.Recover {
+		buf, err := io.ReadAll(io.LimitReader(r.reader, int64(n)))
+		if err == nil && len(buf) < n {
+			err = io.ErrUnexpectedEOF
+		}
+		return buf, err
+	}
+	var buf []byte
+	chunk := make([]byte, min(n, 64<<10))
+	for len(buf) < n {
+		// the marker may start in the previous chunk
+		from := max(len(buf)-len(r.sync)+1, 0)
+		k, err := io.ReadFull(r.reader, chunk[:min(len(chunk), n-len(buf))])
+		buf = append(buf, chunk[:k]...)
+		if bytes.Contains(buf[from:], r.sync[:]) {
+			return nil, errSyncInData
+		}
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func (r *Reader) decompress(batch *Batch) error {
+	buf, err := decompressLimit(r.codec, batch.buf.Bytes(), r.Limits.MaxBlockSize)
+	if err != nil {
+		return err
+	}
+	batch.buf = *bytes.NewBuffer(buf)
+	return nil
+}
+
+// resync positions reader after the next sync marker following the start of corrupt block.
+// raw holds bytes of the block consumed so far, the scan starts from its second byte.
+// Returns io.EOF if end of file reached before the marker.
+func (r *Reader) resync(start int64, raw []byte) error {
+	r.reader.unread(raw[1:])
+	err := r.skipToSync()
+	r.skip(r.reader.offset - start)
+	return err
+}
+
+// skipToSync reads up to and including the next sync marker.
+// Returns io.EOF if end of file reached before the marker.
+func (r *Reader) skipToSync() error {
+	var window [16]byte
+	for n := 0; ; n++ {
+		b, err := r.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		copy(window[:], window[1:])
+		window[15] = b
+		if n >= 15 && window == r.sync {
+			return nil
+		}
+	}
 }
 
 func (b *Batch) Next() bool {
 	if b.recsInBuffer == 0 {
 		return false
 	}
-	b.Value = b.schema.Decode(&b.buf)
-	b.recsInBuffer -= 1
+	var err error
+	b.Value, err = b.next()
+	check(err)
 	return true
 }
+
+// next returns the next record of non-empty batch
+func (b *Batch) next() (interface{}, error) {
+	if !b.decoded {
+		v, err := avro.Decode(b.source(), b.schema)
+		if err == nil {
+			b.recsInBuffer -= 1
+		}
+		return v, err
+	}
+	if len(b.values) == 0 {
+		return nil, b.err
+	}
+	v := b.values[0]
+	b.values = b.values[1:]
+	b.recsInBuffer -= 1
+	return v, nil
+}
+
+func (b *Batch) source() avro.Reader {
+	if b.limits == (binary.Limits{}) {
+		return &b.buf
+	}
+	return binary.WithLimits(&b.buf, b.limits)
+}
+
+// decode decodes all records of the batch in advance
+func (b *Batch) decode() {
+	b.values = make([]interface{}, 0, b.recsInBuffer)
+	for len(b.values) < b.recsInBuffer {
+		v, err := avro.Decode(b.source(), b.schema)
+		if err != nil {
+			b.err = err
+			break
+		}
+		b.values = append(b.values, v)
+	}
+	b.decoded = true
+}
+
+// stream tracks offset in the file and allows to rescan bytes of corrupt block
+type stream struct {
+	r       avro.Reader
+	pending []byte
+	offset  int64
+	// if not nil, record receives every byte read
+	record *bytes.Buffer
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	var n int
+	var err error
+	if len(s.pending) > 0 {
+		n = copy(p, s.pending)
+		s.pending = s.pending[n:]
+	} else {
+		n, err = s.r.Read(p)
+	}
+	s.offset += int64(n)
+	if s.record != nil {
+		s.record.Write(p[:n])
+	}
+	return n, err
+}
+
+func (s *stream) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(s, b[:])
+	return b[0], err
+}
+
+// unread returns data to the stream, to be read again before the rest.
+// The stream may keep data, which must not be modified after the call.
+func (s *stream) unread(data []byte) {
+	if len(s.pending) == 0 {
+		s.pending = data
+	} else {
+		s.pending = append(append([]byte(nil), data...), s.pending...)
+	}
+	s.offset -= int64(len(data))
+}
@@ -0,0 +1,139 @@
+package ocf
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/galtsev/avro"
+	"github.com/galtsev/avro/binary"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+// read all blocks, returning error which stopped the reader
+func readError(data []byte) error {
+	r := openFile(data)
+	for r.NextBatch() {
+	}
+	return r.Err()
+}
+
+func TestReaderHeaderErrors(t *testing.T) {
+	data := writeFile(testRecords(3), nil)
+	_, err := NewReaderWithSchema(bytes.NewBuffer(data), binary.String)
+	assert.IsType(t, binary.ResolutionError{}, err)
+	_, err = NewSeekableReader(bytes.NewReader(data), binary.String)
+	assert.IsType(t, binary.ResolutionError{}, err)
+	_, err = NewReader(bytes.NewBuffer(data[:20]))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, err = NewReader(bytes.NewBuffer(append([]byte("XXXX"), data[4:]...)))
+	assert.Equal(t, errBadMagic, err)
+
+	var buf bytes.Buffer
+	buf.WriteString("Obj\x01")
+	header := map[string]interface{}{"avro.codec": []byte("null")}
+	assert.NoError(t, binary.MapSchema{ValueSchema: binary.Bytes}.Encode(&buf, header))
+	buf.Write(make([]byte, 16))
+	_, err = NewReader(&buf)
+	assert.Equal(t, errNoSchema, err)
+}
+
+func TestSyncMismatch(t *testing.T) {
+	data := writeFile(testRecords(30), func(w *Writer) { w.BatchSize = 10 })
+	// corrupt the sync marker ending the first block
+	header := headerSize(data)
+	first := bytes.Index(data[header:], data[header-16:header]) + header
+	data[first] ^= 0xFF
+	err := readError(data)
+	assert.EqualError(t, err, fmt.Sprintf("ocf: corrupt block at offset %d: sync marker mismatch", header))
+	assert.IsType(t, CorruptionError{}, err)
+}
+
+func TestTruncated(t *testing.T) {
+	data := writeFile(testRecords(30), func(w *Writer) { w.BatchSize = 10 })
+	assert.ErrorIs(t, readError(data[:len(data)-5]), io.ErrUnexpectedEOF)
+	assert.NoError(t, readError(data))
+}
+
+func TestRecover(t *testing.T) {
+	records := testRecords(30)
+	data := writeFile(records, func(w *Writer) { w.BatchSize = 10 })
+	header := headerSize(data)
+	sync := data[header-16 : header]
+	first := bytes.Index(data[header:], sync) + header
+	// claim huge size of the second block
+	data[first+17] = 0x7F
+	r := openFile(data)
+	r.Recover = true
+	var res []interface{}
+	for r.NextBatch() {
+		for r.Batch().Next() {
+			res = append(res, avro.Record{Values: r.Batch().Value.(avro.Record).Values})
+		}
+	}
+	assert.Equal(t, append(records[:10:10], records[20:]...), res)
+	second := bytes.Index(data[first+16:], sync) + first + 32
+	skippedBytes, skippedBlocks := r.Skipped()
+	assert.Equal(t, int64(second-first-16), skippedBytes)
+	assert.Equal(t, 1, skippedBlocks)
+}
+
+func TestRecoverHugeBlock(t *testing.T) {
+	records := testRecords(10000)
+	data := writeFile(records, func(w *Writer) { w.BatchSize = 1000 })
+	header := headerSize(data)
+	sync := data[header-16 : header]
+	first := bytes.Index(data[header:], sync) + header
+	// claim size of the second block about 2GB, following its record count
+	copy(data[first+18:], []byte{0xFE, 0xFF, 0xFF, 0xFF, 0x0F})
+	buf := bytes.NewBuffer(data)
+	r, err := NewReader(buf)
+	assert.NoError(t, err)
+	r.Recover = true
+	assert.True(t, r.NextBatch())
+	assert.True(t, r.NextBatch())
+	// scanning for the next block doesn't read the rest of the file
+	assert.NotZero(t, buf.Len())
+	var res []interface{}
+	for ok := true; ok; ok = r.NextBatch() {
+		for r.Batch().Next() {
+			res = append(res, avro.Record{Values: r.Batch().Value.(avro.Record).Values})
+		}
+	}
+	assert.NoError(t, r.Err())
+	assert.Equal(t, records[2000:], res)
+	_, skippedBlocks := r.Skipped()
+	assert.Equal(t, 1, skippedBlocks)
+}
+
+func TestLimits(t *testing.T) {
+	records := testRecords(100)
+	data := writeFile(records, func(w *Writer) { w.BatchSize = 50 })
+	read := func(limits Limits) error {
+		r := openFile(data)
+		r.Limits = limits
+		for _, err := range r.All() {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	assert.NoError(t, read(Limits{MaxBlockRecords: 50, MaxBlockSize: 1000}))
+	assert.ErrorIs(t, read(Limits{MaxBlockRecords: 49}), binary.LimitError{Kind: "block records", Length: 50, Limit: 49})
+	assert.ErrorAs(t, read(Limits{MaxBlockSize: 100}), &binary.LimitError{})
+	assert.ErrorAs(t, read(Limits{Limits: binary.Limits{MaxBytesLength: 5}}), &binary.LimitError{})
+
+	// decompressed size is limited too
+	for _, codec := range []string{"deflate", "snappy", "zstandard"} {
+		data := writeFile(testRecords(1000), func(w *Writer) { w.Codec = codec })
+		r := openFile(data)
+		r.Limits.MaxBlockSize = 10000
+		it := r.Iterator()
+		assert.False(t, it.Next())
+		var err binary.LimitError
+		assert.ErrorAs(t, it.Err(), &err, codec)
+		assert.Equal(t, "block", err.Kind, codec)
+		assert.Greater(t, err.Length, 10000, codec)
+	}
+}
@@ -0,0 +1,57 @@
+package ocf
+
+import (
+	"bufio"
+	"errors"
+	"github.com/galtsev/avro"
+	"io"
+)
+
+var errNotSeekable = errors.New("ocf: reader is not seekable")
+
+// NewSeekableReader creates reader over file supporting random access with SeekBlock and Sync.
+// The readerSchema argument has the same meaning as in NewReaderWithSchema.
+func NewSeekableReader(f io.ReadSeeker, readerSchema avro.Schema) (*Reader, error) {
+	res, err := NewReaderWithSchema(bufio.NewReader(f), readerSchema)
+	if err != nil {
+		return nil, err
+	}
+	res.seeker = f
+	return res, nil
+}
+
+// BlockOffset returns offset of the block last read by NextBatch.
+// Pass it to SeekBlock to return to the same block later, or to another reader of the file.
+func (r *Reader) BlockOffset() int64 {
+	return r.blockOffset
+}
+
+// SeekBlock positions reader at offset, which must be the start of a block,
+// as returned by BlockOffset. The next call to NextBatch reads that block.
+func (r *Reader) SeekBlock(offset int64) error {
+	if r.seeker == nil {
+		return errNotSeekable
+	}
+	r.stopPrefetch()
+	if _, err := r.seeker.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	r.reader.r = bufio.NewReader(r.seeker)
+	r.reader.pending = nil
+	r.reader.offset = offset
+	r.batch = nil
+	r.err = nil
+	return nil
+}
+
+// Sync positions reader at the first block following sync marker which starts at or after offset.
+// If there is no such marker, next call to NextBatch returns false.
+func (r *Reader) Sync(offset int64) error {
+	if err := r.SeekBlock(offset); err != nil {
+		return err
+	}
+	if err := r.skipToSync(); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package ocf
+
+import (
+	"bytes"
+	"github.com/galtsev/avro"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// read records of all blocks from current position, with block offsets
+func openSeekable(data []byte) *Reader {
+	r, err := NewSeekableReader(bytes.NewReader(data), nil)
+	check(err)
+	return r
+}
+
+func readBlocks(r *Reader) ([]interface{}, []int64) {
+	var res []interface{}
+	var offsets []int64
+	for r.NextBatch() {
+		offsets = append(offsets, r.BlockOffset())
+		for r.Batch().Next() {
+			res = append(res, avro.Record{Values: r.Batch().Value.(avro.Record).Values})
+		}
+	}
+	check(r.Err())
+	return res, offsets
+}
+
+func TestSeek(t *testing.T) {
+	records := testRecords(55)
+	data := writeFile(records, func(w *Writer) { w.BatchSize = 10 })
+	r := openSeekable(data)
+	res, offsets := readBlocks(r)
+	assert.Equal(t, records, res)
+	assert.Len(t, offsets, 6)
+	assert.Equal(t, int64(headerSize(data)), offsets[0])
+
+	assert.NoError(t, r.SeekBlock(offsets[3]))
+	res, _ = readBlocks(r)
+	assert.Equal(t, records[30:], res)
+
+	// a new reader starts at recorded offset
+	r = openSeekable(data)
+	assert.NoError(t, r.SeekBlock(offsets[4]))
+	res, _ = readBlocks(r)
+	assert.Equal(t, records[40:], res)
+}
+
+func TestSync(t *testing.T) {
+	records := testRecords(55)
+	data := writeFile(records, func(w *Writer) { w.BatchSize = 10 })
+	r := openSeekable(data)
+	_, offsets := readBlocks(r)
+
+	// sync marker ending block 1 starts 16 bytes before block 2
+	for _, c := range []struct {
+		offset int64
+		block  int
+	}{
+		{0, 0},
+		{offsets[1] + 1, 2},
+		{offsets[2] - 16, 2},
+		{offsets[2] - 15, 3},
+		{offsets[4], 5},
+	} {
+		assert.NoError(t, r.Sync(c.offset))
+		res, _ := readBlocks(r)
+		assert.Equal(t, records[c.block*10:], res, c.offset)
+	}
+	assert.Equal(t, errNotSeekable, openFile(data).SeekBlock(0))
+}
@@ -0,0 +1,73 @@
+package ocf
+
+import (
+	"fmt"
+	"github.com/galtsev/avro"
+	"io"
+	"sync"
+)
+
+// Split is a [Start, End) byte range of a file.
+// Like Hadoop input splits, it holds the blocks whose preceding sync marker starts within the range,
+// so adjacent splits read each block of the file exactly once.
+type Split struct {
+	Start int64
+	End   int64
+}
+
+// Splits divides file of given size into n ranges of about equal size.
+func Splits(size int64, n int) ([]Split, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("ocf: number of splits should be positive, found %d", n)
+	}
+	res := make([]Split, n)
+	for i := range res {
+		res[i] = Split{Start: size * int64(i) / int64(n), End: size * int64(i+1) / int64(n)}
+	}
+	return res, nil
+}
+
+// NewSplitReader creates reader returning only the blocks of split.
+func NewSplitReader(f io.ReadSeeker, readerSchema avro.Schema, split Split) (*Reader, error) {
+	res, err := NewSeekableReader(f, readerSchema)
+	if err != nil {
+		return nil, err
+	}
+	if err := res.Sync(split.Start); err != nil {
+		return nil, err
+	}
+	res.end = split.End
+	return res, nil
+}
+
+// ReadSplits reads file in n splits in parallel, calling fn with a reader of every split
+// from its own goroutine. Results are returned in the order of splits,
+// and the first error, by split order, is returned.
+func ReadSplits[T any](f io.ReaderAt, size int64, n int, readerSchema avro.Schema, fn func(r *Reader) (T, error)) ([]T, error) {
+	splits, err := Splits(size, n)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]T, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i, split := range splits {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := NewSplitReader(io.NewSectionReader(f, 0, size), readerSchema, split)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			res[i], errs[i] = fn(r)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,68 @@
+package ocf
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSplitReader(t *testing.T) {
+	records := testRecords(55)
+	data := writeFile(records, func(w *Writer) { w.BatchSize = 10 })
+	// any partition of the file reads every record once
+	for _, n := range []int{1, 2, 3, 7, 50} {
+		var res []interface{}
+		splits, err := Splits(int64(len(data)), n)
+		assert.NoError(t, err)
+		for _, split := range splits {
+			r, err := NewSplitReader(bytes.NewReader(data), nil, split)
+			assert.NoError(t, err)
+			recs, _ := readBlocks(r)
+			res = append(res, recs...)
+		}
+		assert.Equal(t, records, res, n)
+	}
+
+	_, offsets := readBlocks(openSeekable(data))
+	r, err := NewSplitReader(bytes.NewReader(data), nil, Split{Start: offsets[1] - 16, End: offsets[3] - 16})
+	assert.NoError(t, err)
+	res, _ := readBlocks(r)
+	assert.Equal(t, records[10:30], res)
+
+	_, err = NewSplitReader(bytes.NewReader(data[:10]), nil, Split{End: 10})
+	assert.Error(t, err)
+
+	for _, n := range []int{0, -1} {
+		_, err = Splits(int64(len(data)), n)
+		assert.EqualError(t, err, fmt.Sprintf("ocf: number of splits should be positive, found %d", n))
+	}
+}
+
+func TestReadSplits(t *testing.T) {
+	records := testRecords(1000)
+	data := writeFile(records, func(w *Writer) { w.BatchSize = 30 })
+	parts, err := ReadSplits(bytes.NewReader(data), int64(len(data)), 4, nil, func(r *Reader) ([]interface{}, error) {
+		recs, _ := readBlocks(r)
+		return recs, nil
+	})
+	assert.NoError(t, err)
+	var res []interface{}
+	for _, recs := range parts {
+		res = append(res, recs...)
+	}
+	assert.Equal(t, records, res)
+
+	data[len(data)-1] ^= 0xFF
+	_, err = ReadSplits(bytes.NewReader(data), int64(len(data)), 4, nil, func(r *Reader) (int, error) {
+		n := 0
+		for r.NextBatch() {
+			n += 1
+		}
+		return n, r.Err()
+	})
+	assert.IsType(t, CorruptionError{}, err)
+
+	_, err = ReadSplits(bytes.NewReader(data), int64(len(data)), 0, nil, func(r *Reader) (int, error) { return 0, nil })
+	assert.Error(t, err)
+}
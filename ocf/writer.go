@@ -1,11 +1,15 @@
 package ocf
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
+	"fmt"
 	"github.com/galtsev/avro"
 	"github.com/galtsev/avro/binary"
 	"io"
 	"math/rand"
+	"strings"
 )
 
 func check(err error) {
@@ -21,46 +25,243 @@ type Writer struct {
 	schema       avro.Schema
 	jschema      string
 	BatchSize    int
-	syncString   [16]byte
+	// BlockSize is the target size of uncompressed block in bytes: block is flushed
+	// when buffered records reach either BatchSize records or BlockSize bytes.
+	// NewWriter sets it to 1 MiB, zero means no limit on the size.
+	BlockSize int
+	// Codec is the name of block compression codec: "null", "deflate", "snappy",
+	// "zstandard" or one added with RegisterCodec. Set it before the header is written.
+	Codec      string
+	codec      Codec
+	syncString [16]byte
+	metadata   map[string][]byte
+	// CloseWriter makes Close also close the underlying writer, if it is an io.Closer
+	CloseWriter bool
+	// header is already in the file, set in append mode
+	headerWritten bool
+	closed        bool
+	// Concurrency is the number of blocks compressed in parallel.
+	// Blocks are still written in order, by a background goroutine.
+	// Values of 0 and 1 mean blocks are compressed and written by Flush.
+	// Set it before the first Write.
+	Concurrency int
+	writerPipeline
 }
 
-func (fw *Writer) WriteHeader() {
-	fw.writer.Write([]byte("Obj\x01"))
+var (
+	errClosed        = errors.New("ocf: writer is closed")
+	errHeaderWritten = errors.New("ocf: metadata can't be set after the header is written")
+)
+
+// ReservedKeyError reports attempt to set metadata key in the reserved "avro." namespace.
+type ReservedKeyError struct {
+	Key string
+}
+
+func (err ReservedKeyError) Error() string {
+	return fmt.Sprintf("ocf: metadata key %q is reserved", err.Key)
+}
+
+// SetMetadata adds user key/value to the file header. Keys starting with "avro."
+// are reserved for the schema, codec and other entries defined by the spec.
+// Metadata can't be set after the header is written, which includes appending to a file.
+func (fw *Writer) SetMetadata(key string, value []byte) error {
+	if strings.HasPrefix(key, "avro.") {
+		return ReservedKeyError{Key: key}
+	}
+	if fw.headerWritten {
+		return errHeaderWritten
+	}
+	fw.metadata[key] = value
+	return nil
+}
+
+// WriteHeader writes file header. It is called by the first Write, Flush or Close,
+// so there is no need to call it explicitly.
+func (fw *Writer) WriteHeader() error {
+	if fw.headerWritten {
+		return nil
+	}
+	var err error
+	fw.codec, err = getCodec(fw.Codec)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	out.WriteString("Obj\x01")
 	header := make(map[string]interface{})
+	for k, v := range fw.metadata {
+		header[k] = v
+	}
 	header["avro.schema"] = []byte(fw.jschema)
-	header["avro.codec"] = []byte("null")
+	header["avro.codec"] = []byte(fw.Codec)
 	headerSchema := binary.MapSchema{ValueSchema: binary.Bytes}
-	headerSchema.Encode(fw.writer, header)
-	_, err := fw.writer.Write(fw.syncString[:])
-	check(err)
+	if err = headerSchema.Encode(&out, header); err != nil {
+		return err
+	}
+	out.Write(fw.syncString[:])
+	if _, err = out.WriteTo(fw.writer); err != nil {
+		return err
+	}
+	fw.headerWritten = true
+	return nil
 }
 
-func (fw *Writer) Write(v interface{}) {
-	fw.schema.Encode(&fw.buf, v)
+// Write adds v to the current block, flushing it when BatchSize records or BlockSize bytes are buffered.
+// Value which fails to encode is not added.
+func (fw *Writer) Write(v interface{}) error {
+	if fw.closed {
+		return errClosed
+	}
+	if err := fw.asyncError(); err != nil {
+		return err
+	}
+	if err := fw.WriteHeader(); err != nil {
+		return err
+	}
+	size := fw.buf.Len()
+	if err := fw.schema.Encode(&fw.buf, v); err != nil {
+		fw.buf.Truncate(size)
+		return err
+	}
 	fw.recsInBuffer += 1
-	if fw.recsInBuffer >= fw.BatchSize {
-		fw.Flush()
+	if fw.recsInBuffer >= fw.BatchSize || fw.BlockSize > 0 && fw.buf.Len() >= fw.BlockSize {
+		return fw.flushBlock()
+	}
+	return nil
+}
+
+// Flush writes buffered records as a block. Nothing is written if there are no records.
+// With Concurrency set, it waits until all queued blocks are written.
+func (fw *Writer) Flush() error {
+	if fw.closed {
+		return errClosed
 	}
+	if err := fw.WriteHeader(); err != nil {
+		return err
+	}
+	if err := fw.flushBlock(); err != nil {
+		return err
+	}
+	fw.pending.Wait()
+	return fw.asyncError()
 }
 
-func (fw *Writer) Flush() {
-	binary.EncodeVarInt(fw.writer, fw.recsInBuffer)
-	binary.EncodeVarInt(fw.writer, len(fw.buf.Bytes()))
-	io.Copy(fw.writer, &fw.buf)
-	_, err := fw.writer.Write(fw.syncString[:])
-	check(err)
+func (fw *Writer) flushBlock() error {
+	if fw.recsInBuffer == 0 {
+		return nil
+	}
+	if fw.Concurrency > 1 {
+		fw.queueBlock()
+	} else {
+		block, err := fw.codec.Compress(fw.buf.Bytes())
+		if err != nil {
+			return err
+		}
+		if err = fw.writeBlock(fw.recsInBuffer, block); err != nil {
+			return err
+		}
+	}
 	fw.recsInBuffer = 0
 	fw.buf.Reset()
+	return nil
+}
+
+func (fw *Writer) writeBlock(count int, block []byte) error {
+	var out bytes.Buffer
+	binary.EncodeVarInt(&out, count)
+	binary.EncodeVarInt(&out, len(block))
+	out.Write(block)
+	out.Write(fw.syncString[:])
+	_, err := out.WriteTo(fw.writer)
+	return err
 }
 
-func NewWriter(w io.Writer, schema string) *Writer {
+// Close flushes buffered records and, if CloseWriter is set, closes the underlying writer.
+// Writer can't be used after Close.
+func (fw *Writer) Close() error {
+	if fw.closed {
+		return errClosed
+	}
+	err := fw.Flush()
+	fw.closed = true
+	if fw.queue != nil {
+		close(fw.queue)
+	}
+	if c, ok := fw.writer.(io.Closer); ok && fw.CloseWriter {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// NewWriter creates writer of a new file with schema, failing if schema is invalid.
+func NewWriter(w io.Writer, schema string) (*Writer, error) {
 	repo := binary.NewRepo()
+	parsed, err := repo.Append(schema)
+	if err != nil {
+		return nil, err
+	}
 	res := Writer{
 		writer:    w,
 		jschema:   schema,
-		schema:    repo.Append(schema),
+		schema:    parsed,
 		BatchSize: 1000,
+		BlockSize: 1 << 20,
+		Codec:     "null",
+		metadata:  make(map[string][]byte),
 	}
 	rand.Read(res.syncString[:])
-	return &res
+	return &res, nil
+}
+
+// NewAppendWriter creates writer adding blocks to the end of existing file.
+// Schema, codec and sync marker are taken from the file header.
+// If schema is not empty, it must have the same Parsing Canonical Form as the schema of the file.
+func NewAppendWriter(f io.ReadWriteSeeker, schema string) (*Writer, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	r, err := NewReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	res := Writer{
+		writer:        f,
+		jschema:       string(r.metadata["avro.schema"]),
+		BatchSize:     1000,
+		BlockSize:     1 << 20,
+		Codec:         string(r.metadata["avro.codec"]),
+		codec:         r.codec,
+		schema:        r.schema,
+		metadata:      r.metadata,
+		syncString:    r.sync,
+		headerWritten: true,
+	}
+	if res.Codec == "" {
+		res.Codec = "null"
+	}
+	if schema != "" {
+		parsed, err := binary.NewRepo().Append(schema)
+		if err != nil {
+			return nil, err
+		}
+		// schemas are the same if their Parsing Canonical Forms are
+		canonical, err := binary.CanonicalForm(parsed)
+		if err != nil {
+			return nil, err
+		}
+		fileCanonical, err := binary.CanonicalForm(res.schema)
+		if err != nil {
+			return nil, err
+		}
+		if canonical != fileCanonical {
+			return nil, fmt.Errorf("ocf: schema %s doesn't match file schema %s", canonical, fileCanonical)
+		}
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return nil, err
+	}
+	return &res, nil
 }
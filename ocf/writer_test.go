@@ -0,0 +1,169 @@
+package ocf
+
+import (
+	"bytes"
+	"errors"
+	"github.com/galtsev/avro"
+	"github.com/galtsev/avro/binary"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func TestMetadata(t *testing.T) {
+	data := writeFile(testRecords(3), func(w *Writer) {
+		assert.NoError(t, w.SetMetadata("producer", []byte("test 1.0")))
+		assert.Equal(t, ReservedKeyError{Key: "avro.codec"}, w.SetMetadata("avro.codec", []byte("lzma")))
+		w.Codec = "deflate"
+		assert.NoError(t, w.WriteHeader())
+		assert.Equal(t, errHeaderWritten, w.SetMetadata("late", []byte("x")))
+	})
+	r := openFile(data)
+	assert.Equal(t, map[string][]byte{
+		"avro.schema": []byte(testSchema),
+		"avro.codec":  []byte("deflate"),
+		"producer":    []byte("test 1.0"),
+	}, r.Metadata())
+	assert.Equal(t, testRecords(3), readFile(data))
+}
+
+// in-memory io.ReadWriteSeeker
+type memFile struct {
+	data []byte
+	pos  int
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.pos >= len(f.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[f.pos:])
+	f.pos += n
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.data = append(f.data[:f.pos], p...)
+	f.pos = len(f.data)
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += int64(f.pos)
+	case io.SeekEnd:
+		offset += int64(len(f.data))
+	}
+	f.pos = int(offset)
+	return offset, nil
+}
+
+func TestAppend(t *testing.T) {
+	records := testRecords(30)
+	f := &memFile{data: writeFile(records[:10], func(w *Writer) { w.Codec = "deflate" })}
+	w, err := NewAppendWriter(f, "")
+	assert.NoError(t, err)
+	assert.Equal(t, errHeaderWritten, w.SetMetadata("producer", []byte("test 1.0")))
+	for _, rec := range records[10:20] {
+		assert.NoError(t, w.Write(rec))
+	}
+	assert.NoError(t, w.Close())
+	w, err = NewAppendWriter(f, `{"type": "record", "name": "rec", "fields": [
+		{"name": "id", "type": "long"}, {"name": "name", "type": "string"}]}`)
+	assert.NoError(t, err)
+	for _, rec := range records[20:] {
+		assert.NoError(t, w.Write(rec))
+	}
+	assert.NoError(t, w.Close())
+	assert.Equal(t, records, readFile(f.data))
+	assert.Equal(t, "deflate", string(openFile(f.data).Metadata()["avro.codec"]))
+}
+
+func TestAppendSchemaMismatch(t *testing.T) {
+	f := &memFile{data: writeFile(testRecords(3), nil)}
+	_, err := NewAppendWriter(f, `{"type": "record", "name": "rec", "fields": [{"name": "id", "type": "int"}]}`)
+	assert.EqualError(t, err, `ocf: schema {"name":"rec","type":"record","fields":[{"name":"id","type":"int"}]} `+
+		`doesn't match file schema {"name":"rec","type":"record","fields":[{"name":"id","type":"long"},{"name":"name","type":"string"}]}`)
+	// attributes outside of the canonical form, like defaults, don't matter
+	f = &memFile{data: writeFile(testRecords(3), nil)}
+	_, err = NewAppendWriter(f, `{"type": "record", "name": "rec", "fields": [
+		{"name": "id", "type": "long"}, {"name": "name", "type": "string", "default": "x"}]}`)
+	assert.NoError(t, err)
+	_, err = NewAppendWriter(&memFile{data: []byte("Obj\x01")}, "")
+	assert.Error(t, err)
+}
+
+// writer failing after limit bytes
+type failingWriter struct {
+	limit  int
+	closed bool
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		return 0, errors.New("disk full")
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func (w *failingWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestWriterErrors(t *testing.T) {
+	_, err := NewWriter(&bytes.Buffer{}, `{"type": "record", "name": "r"}`)
+	assert.IsType(t, binary.SchemaError{}, err)
+
+	w := newWriter(&failingWriter{limit: 10})
+	assert.EqualError(t, w.Write(testRecords(1)[0]), "disk full")
+
+	fw := &failingWriter{limit: 1000}
+	w = newWriter(fw)
+	w.CloseWriter = true
+	assert.NoError(t, w.Write(testRecords(1)[0]))
+	assert.Error(t, w.Write(avro.Record{Values: []interface{}{1, 2}}))
+	fw.limit = 0
+	assert.EqualError(t, w.Flush(), "disk full")
+	fw.limit = 1000
+	assert.NoError(t, w.Close())
+	assert.True(t, fw.closed)
+	assert.Equal(t, errClosed, w.Write(testRecords(1)[0]))
+	assert.Equal(t, errClosed, w.Close())
+}
+
+func TestWriterBlocks(t *testing.T) {
+	// header is written by Close, no empty blocks
+	data := writeFile(nil, nil)
+	assert.Equal(t, headerSize(data), len(data))
+	assert.Empty(t, readFile(data))
+
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	w.BatchSize = 10
+	for _, rec := range testRecords(20) {
+		assert.NoError(t, w.Write(rec))
+	}
+	assert.NoError(t, w.Flush())
+	assert.NoError(t, w.Close())
+	_, offsets := readBlocks(openSeekable(buf.Bytes()))
+	assert.Len(t, offsets, 2)
+	assert.Equal(t, testRecords(20), readFile(buf.Bytes()))
+}
+
+func TestBlockSize(t *testing.T) {
+	records := testRecords(100)
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	// records are 16 bytes long
+	w.BlockSize = 100
+	for _, rec := range records {
+		assert.NoError(t, w.Write(rec))
+	}
+	assert.NoError(t, w.Close())
+	_, offsets := readBlocks(openSeekable(buf.Bytes()))
+	assert.Len(t, offsets, 15)
+	assert.Equal(t, records, readFile(buf.Bytes()))
+}